@@ -0,0 +1,275 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package aptconfig implements the configuration tree APT sends to methods in
+// the Config-Item fields of its 601 Configuration message, e.g.
+//
+// 601 Configuration
+// Config-Item: Acquire::s3::region=us-east-2
+// Config-Item: APT::Update::Post-Invoke-Success::=touch%20/var/lib/apt/periodic/update-success-stamp
+//
+// Like in APT, the names of items are "::" separated paths into the tree that
+// are looked up case-insensitively, and items whose last tag is empty, e.g.
+// "Foo::=bar", are appended to the list of values of their parent.
+package aptconfig
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// separator separates the tags of the name of an item.
+const separator = "::"
+
+// ErrInvalidItem is returned for Config-Item values that are not of the form
+// <name>=<value>.
+var ErrInvalidItem = errors.New("invalid configuration item")
+
+// A Config is a tree of APT configuration items. A Config must not be
+// modified while it is read from other goroutines. A nil *Config is empty.
+type Config struct {
+	root *node
+}
+
+// A node is an item of the tree. Intermediate nodes only have a value if one
+// was set for them, e.g. Acquire::s3::role in addition to
+// Acquire::s3::role::duration.
+type node struct {
+	tag      string
+	value    string
+	hasValue bool
+	children []*node
+}
+
+// New returns an empty Config.
+func New() *Config {
+	return &Config{root: &node{}}
+}
+
+// Parse builds a Config from the values of the Config-Item fields of a
+// Configuration message. Invalid items are skipped, and the error for the
+// first of them is returned along with the Config of the others.
+func Parse(items []string) (*Config, error) {
+	cfg := New()
+	var firstErr error
+	for _, item := range items {
+		if err := cfg.Add(item); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return cfg, firstErr
+}
+
+// Add sets the item given as the value of a Config-Item field, i.e. its name
+// and value separated by "=", both URL encoded the way APT encodes them.
+func (cfg *Config) Add(item string) error {
+	idx := strings.Index(item, "=")
+	if idx < 1 {
+		return fmt.Errorf("%w: %q", ErrInvalidItem, item)
+	}
+	cfg.Set(unquote(item[:idx]), unquote(item[idx+1:]))
+	return nil
+}
+
+// Set sets the value of the named item, creating any items on its path that
+// don't exist yet. If the last tag of the name is empty, e.g. "Foo::", the
+// value is appended to the list of values of its parent instead.
+func (cfg *Config) Set(name, value string) {
+	n := cfg.root
+	tags := strings.Split(name, separator)
+	for i, tag := range tags {
+		if tag == "" && i == len(tags)-1 && i > 0 {
+			n.children = append(n.children, &node{value: value, hasValue: true})
+			return
+		}
+		child := n.child(tag)
+		if child == nil {
+			child = &node{tag: tag}
+			n.children = append(n.children, child)
+		}
+		n = child
+	}
+	n.value, n.hasValue = value, true
+}
+
+// Find returns the value of the named item and reports whether it was set.
+func (cfg *Config) Find(name string) (string, bool) {
+	n := cfg.lookup(name)
+	if n == nil || !n.hasValue {
+		return "", false
+	}
+	return n.value, true
+}
+
+// Lookup returns the value of the first of the named items that was set, and
+// reports whether there was one. Names are passed from the most to the least
+// specific scope, e.g.
+//
+// cfg.Lookup("Acquire::s3::Proxy::"+host, "Acquire::s3::Proxy")
+func (cfg *Config) Lookup(names ...string) (string, bool) {
+	for _, name := range names {
+		if value, ok := cfg.Find(name); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// String returns the value of the named item, or fallback if it was not set.
+func (cfg *Config) String(name, fallback string) string {
+	if value, ok := cfg.Find(name); ok {
+		return value
+	}
+	return fallback
+}
+
+// Bool returns the value of the named item parsed the way APT parses boolean
+// options, or fallback if it was not set or is not a boolean.
+func (cfg *Config) Bool(name string, fallback bool) bool {
+	value, _ := cfg.Find(name)
+	switch strings.ToLower(value) {
+	case "1", "yes", "true", "with", "on", "enable":
+		return true
+	case "0", "no", "false", "without", "off", "disable":
+		return false
+	default:
+		return fallback
+	}
+}
+
+// Int returns the value of the named item as an integer, or fallback if it
+// was not set or is not an integer.
+func (cfg *Config) Int(name string, fallback int) int {
+	value, _ := cfg.Find(name)
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return fallback
+	}
+	return n
+}
+
+// Duration returns the value of the named item as a duration, or fallback if
+// it was not set or is not a duration. Plain numbers are taken as seconds, as
+// is common in APT configuration; otherwise the value is parsed by
+// time.ParseDuration, e.g. "15m".
+func (cfg *Config) Duration(name string, fallback time.Duration) time.Duration {
+	value, _ := cfg.Find(name)
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fallback
+	}
+	return d
+}
+
+// List returns the values of the children of the named item, e.g. those
+// appended with "Foo::=bar", in the order they were set.
+func (cfg *Config) List(name string) []string {
+	n := cfg.lookup(name)
+	if n == nil {
+		return nil
+	}
+	var values []string
+	for _, child := range n.children {
+		if child.hasValue {
+			values = append(values, child.value)
+		}
+	}
+	return values
+}
+
+// Tags returns the tags of the children of the named item, as they were first
+// set, in the order they were first set. List items have no tag and are not
+// included. The root of the tree is named by the empty string.
+func (cfg *Config) Tags(name string) []string {
+	n := cfg.lookup(name)
+	if n == nil {
+		return nil
+	}
+	var tags []string
+	for _, child := range n.children {
+		if child.tag != "" {
+			tags = append(tags, child.tag)
+		}
+	}
+	return tags
+}
+
+// Sub returns the subtree of the named item, whose items are named relative
+// to it. It is empty if the item does not exist.
+func (cfg *Config) Sub(name string) *Config {
+	n := cfg.lookup(name)
+	if n == nil {
+		return New()
+	}
+	return &Config{root: n}
+}
+
+// lookup returns the node of the named item, or nil if there is none.
+func (cfg *Config) lookup(name string) *node {
+	if cfg == nil {
+		return nil
+	}
+	n := cfg.root
+	if name == "" {
+		return n
+	}
+	for _, tag := range strings.Split(name, separator) {
+		if n = n.child(tag); n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// child returns the child of n with the given tag, compared case-insensitively
+// like APT does, or nil if there is none.
+func (n *node) child(tag string) *node {
+	if tag == "" {
+		return nil
+	}
+	for _, child := range n.children {
+		if strings.EqualFold(child.tag, tag) {
+			return child
+		}
+	}
+	return nil
+}
+
+// unquote decodes the %XX escapes APT uses for characters such as spaces and
+// newlines in Config-Item values. Other occurrences of "%" are left as they
+// are, like APT's DeQuoteString does.
+func unquote(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
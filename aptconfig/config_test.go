@@ -0,0 +1,240 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aptconfig
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+//nolint:lll,gochecknoglobals
+var configItems = []string{
+	"APT::Architecture=amd64",
+	"APT::Build-Essential::=build-essential",
+	"APT::Color::Highlight=%1b[32m",
+	"APT::Update::Post-Invoke-Success::=touch%20/var/lib/apt/periodic/update-success-stamp%202>/dev/null%20||%20true",
+	"DPkg::Pre-Install-Pkgs::=/usr/sbin/dpkg-preconfigure%20--apt%20||%20true",
+	"DPkg::Pre-Install-Pkgs::=/usr/bin/apt-listchanges%20--apt",
+	"Acquire::s3::region=us-east-2",
+	"Acquire::s3::role=arn:aws:iam::123456789012:role/apt",
+	"Acquire::s3::role::duration=900",
+	"Acquire::s3::Bucket::my-bucket::region=eu-west-1",
+	"Acquire::s3::Bucket::other-bucket::profile=other",
+	"Dir=/",
+	"Unattended-Upgrade::Allowed-Origins::=${distro_id}:${distro_codename}-security",
+}
+
+func TestFind(t *testing.T) {
+	cfg, err := Parse(configItems)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	specs := map[string]struct {
+		expected      string
+		expectedFound bool
+	}{
+		"Acquire::s3::region":             {"us-east-2", true},
+		"ACQUIRE::S3::Region":             {"us-east-2", true},
+		"Acquire::s3::role":               {"arn:aws:iam::123456789012:role/apt", true},
+		"Acquire::s3::role::duration":     {"900", true},
+		"APT::Color::Highlight":           {"\x1b[32m", true},
+		"Acquire::s3":                     {"", false},
+		"Acquire::s3::endpoint":           {"", false},
+		"Acquire::s3::region::unexpected": {"", false},
+	}
+
+	for name, spec := range specs {
+		t.Run(name, func(t *testing.T) {
+			actual, found := cfg.Find(name)
+			if actual != spec.expected || found != spec.expectedFound {
+				t.Errorf("Find(%q) = %q, %t; expected %q, %t", name, actual, found, spec.expected, spec.expectedFound)
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	cfg, err := Parse(configItems)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	specs := map[string][]string{
+		"DPkg::Pre-Install-Pkgs": {
+			"/usr/sbin/dpkg-preconfigure --apt || true",
+			"/usr/bin/apt-listchanges --apt",
+		},
+		"apt::update::post-invoke-success": {
+			"touch /var/lib/apt/periodic/update-success-stamp 2>/dev/null || true",
+		},
+		"APT::Missing": nil,
+	}
+
+	for name, expected := range specs {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(expected, cfg.List(name)); diff != "" {
+				t.Errorf("List(%q) mismatch (-expected +actual):\n%s", name, diff)
+			}
+		})
+	}
+}
+
+func TestTagsAndSub(t *testing.T) {
+	cfg, err := Parse(configItems)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	buckets := cfg.Tags("Acquire::s3::Bucket")
+	if diff := cmp.Diff([]string{"my-bucket", "other-bucket"}, buckets); diff != "" {
+		t.Errorf("Tags() mismatch (-expected +actual):\n%s", diff)
+	}
+	if tags := cfg.Tags("APT::Build-Essential"); len(tags) != 0 {
+		t.Errorf("Tags(APT::Build-Essential) = %v; expected no tags for list items", tags)
+	}
+
+	sub := cfg.Sub("Acquire::s3::Bucket::my-bucket")
+	if actual := sub.String("region", ""); actual != "eu-west-1" {
+		t.Errorf("Sub().String(region) = %q; expected %q", actual, "eu-west-1")
+	}
+	if actual := cfg.Sub("Acquire::s3::Bucket::missing").String("region", "fallback"); actual != "fallback" {
+		t.Errorf("Sub(missing).String(region) = %q; expected %q", actual, "fallback")
+	}
+}
+
+func TestLookup(t *testing.T) {
+	cfg, err := Parse([]string{
+		"Acquire::s3::Proxy=http://s3-proxy.internal:3128",
+		"Acquire::s3::Proxy::minio.internal=DIRECT",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	specs := map[string]string{
+		"minio.internal":   "DIRECT",
+		"s3.amazonaws.com": "http://s3-proxy.internal:3128",
+	}
+	for host, expected := range specs {
+		actual, found := cfg.Lookup("Acquire::s3::Proxy::"+host, "Acquire::s3::Proxy")
+		if !found || actual != expected {
+			t.Errorf("Lookup(%s) = %q, %t; expected %q, %t", host, actual, found, expected, true)
+		}
+	}
+	if actual, found := cfg.Lookup("Acquire::http::Proxy", "Acquire::https::Proxy"); found {
+		t.Errorf("Lookup() = %q, %t; expected no value", actual, found)
+	}
+}
+
+func TestSet(t *testing.T) {
+	cfg := New()
+	cfg.Set("Acquire::s3::region", "us-east-2")
+	cfg.Set("acquire::S3::REGION", "eu-west-1")
+	cfg.Set("Acquire::s3::Bucket::", "my-bucket")
+	cfg.Set("Acquire::s3::Bucket::", "other-bucket")
+
+	if actual := cfg.String("Acquire::s3::region", ""); actual != "eu-west-1" {
+		t.Errorf("String(Acquire::s3::region) = %q; expected %q", actual, "eu-west-1")
+	}
+	if diff := cmp.Diff([]string{"region", "Bucket"}, cfg.Tags("Acquire::s3")); diff != "" {
+		t.Errorf("Tags() mismatch (-expected +actual):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"my-bucket", "other-bucket"}, cfg.List("Acquire::s3::Bucket")); diff != "" {
+		t.Errorf("List() mismatch (-expected +actual):\n%s", diff)
+	}
+}
+
+func TestParseInvalidItem(t *testing.T) {
+	cfg, err := Parse([]string{"Acquire::s3::region", "=us-east-2", "Dir=/"})
+	if !errors.Is(err, ErrInvalidItem) {
+		t.Errorf("Parse() error = %v; expected %v", err, ErrInvalidItem)
+	}
+	if actual := cfg.String("Dir", ""); actual != "/" {
+		t.Errorf("String(Dir) = %q; expected valid items to be kept", actual)
+	}
+}
+
+func TestBool(t *testing.T) {
+	specs := map[string]bool{
+		"true":   true,
+		"Yes":    true,
+		"1":      true,
+		"false":  false,
+		"no":     false,
+		"0":      false,
+		"maybe":  true,
+		"":       true,
+		"enable": true,
+	}
+	for value, expected := range specs {
+		cfg := New()
+		cfg.Set("Acquire::s3::force-path-style", value)
+		if actual := cfg.Bool("Acquire::s3::force-path-style", true); actual != expected {
+			t.Errorf("Bool() for %q = %t; expected %t", value, actual, expected)
+		}
+	}
+	if !New().Bool("Acquire::s3::force-path-style", true) {
+		t.Errorf("Bool() for an unset item = false; expected the fallback")
+	}
+}
+
+func TestInt(t *testing.T) {
+	specs := map[string]int{
+		"4":    4,
+		" 8 ":  8,
+		"-1":   -1,
+		"many": 5,
+		"":     5,
+	}
+	for value, expected := range specs {
+		cfg := New()
+		cfg.Set("Acquire::s3::Max-Parallel", value)
+		if actual := cfg.Int("Acquire::s3::Max-Parallel", 5); actual != expected {
+			t.Errorf("Int() for %q = %d; expected %d", value, actual, expected)
+		}
+	}
+}
+
+func TestDuration(t *testing.T) {
+	specs := map[string]time.Duration{
+		"3600":    time.Hour,
+		"15m":     15 * time.Minute,
+		"0":       0,
+		"-5s":     -5 * time.Second,
+		"forever": time.Minute,
+	}
+	for value, expected := range specs {
+		cfg := New()
+		cfg.Set("Acquire::s3::role::duration", value)
+		if actual := cfg.Duration("Acquire::s3::role::duration", time.Minute); actual != expected {
+			t.Errorf("Duration() for %q = %s; expected %s", value, actual, expected)
+		}
+	}
+}
+
+func TestUnquote(t *testing.T) {
+	specs := map[string]string{
+		"plain":                   "plain",
+		"apt-get%20install%20foo": "apt-get install foo",
+		"100%":                    "100%",
+		"%zz":                     "%zz",
+		"%3d%0A":                  "=\n",
+	}
+	for value, expected := range specs {
+		if actual := unquote(value); actual != expected {
+			t.Errorf("unquote(%q) = %q; expected %q", value, actual, expected)
+		}
+	}
+}
@@ -21,7 +21,9 @@ import (
 	"flag"
 	"log"
 	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
 
 	"github.com/google/apt-golang-s3/method"
 )
@@ -33,6 +35,13 @@ const (
 var (
 	//nolint:gochecknoglobals
 	showVersion = flag.Bool("version", false, "Print version and exit")
+	// APT never passes flags to methods, so this is only useful when running
+	// the method by hand, e.g. in tests. It can't be read from the
+	// configuration, since it bounds the wait for that configuration.
+	//
+	//nolint:gochecknoglobals
+	configTimeout = flag.Duration("config-timeout", method.DefaultConfigTimeout,
+		"How long to wait for the configuration from APT before using defaults (for testing only)")
 )
 
 func main() {
@@ -44,5 +53,12 @@ func main() {
 		os.Exit(0)
 	}
 
-	method.New(logger).Run()
+	// Go exits on SIGPIPE when a write to stdout fails because APT has gone
+	// away. Ignoring it lets the method notice the failed write instead and
+	// cancel any in-flight downloads.
+	signal.Ignore(syscall.SIGPIPE)
+
+	if err := method.New(logger, method.WithConfigTimeout(*configTimeout)).Run(); err != nil {
+		log.Fatalf("apt-golang-s3: %v", err)
+	}
 }
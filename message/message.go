@@ -23,6 +23,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"unicode"
 )
 
 // Header models the first line of a message specified by the APT method
@@ -47,9 +48,10 @@ type Message struct {
 }
 
 // FromBytes takes a byte representation of a Message and unmarshals it into a
-// Message.
-func FromBytes(b []byte) (*Message, error) {
-	msg, err := parse(string(b))
+// Message. Malformed lines are reported as a *ParseError, unless the Lenient
+// option is given.
+func FromBytes(b []byte, opts ...ParseOption) (*Message, error) {
+	msg, err := newParser(opts).parse(strings.Split(string(b), "\n"), 1)
 	if err != nil {
 		return nil, err
 	}
@@ -58,10 +60,12 @@ func FromBytes(b []byte) (*Message, error) {
 
 // GetFieldValue returns the Value property of the Field with the given name.
 // If no field is found with the given name, it returns a zero length string.
-// This is useful for Fields that appear only once in a given Message.
+// This is useful for Fields that appear only once in a given Message. Like in
+// APT, names are compared case-insensitively and the first of repeated Fields
+// wins.
 func (msg *Message) GetFieldValue(name string) (string, bool) {
 	for _, f := range msg.Fields {
-		if f.Name == name {
+		if strings.EqualFold(f.Name, name) {
 			return f.Value, true
 		}
 	}
@@ -70,11 +74,11 @@ func (msg *Message) GetFieldValue(name string) (string, bool) {
 
 // GetFieldList returns a slice of Fields with the given name. This is useful
 // when looking for a collection of fields with a given name from the same
-// Message, e.g. 'Config-Item'.
+// Message, e.g. 'Config-Item'. Names are compared case-insensitively.
 func (msg *Message) GetFieldList(name string) []*Field {
 	fields := []*Field{}
 	for _, f := range msg.Fields {
-		if f.Name == name {
+		if strings.EqualFold(f.Name, name) {
 			fields = append(fields, f)
 		}
 	}
@@ -95,44 +99,149 @@ func (msg *Message) String() string {
 // String returns a string representation of a Header formatted according to
 // the APT method interface.
 func (h *Header) String() string {
-	return fmt.Sprintf("%d %s", h.Status, h.Description)
+	return fmt.Sprintf("%03d %s", h.Status, h.Description)
 }
 
 // String returns a string representation of a Field formatted according to the
-// APT method interface.
+// APT method interface. Values spanning multiple lines are written with
+// continuation lines.
 func (f *Field) String() string {
-	return fmt.Sprintf("%s: %s", f.Name, f.Value)
+	return fmt.Sprintf("%s: %s", f.Name, strings.ReplaceAll(f.Value, "\n", "\n "))
 }
 
 var (
 	errMsgMissingRequiredLines = errors.New("message missing required number of lines")
+
+	// ErrInvalidHeader reports a header line that is not a three digit status
+	// code followed by a description.
+	ErrInvalidHeader = errors.New("invalid header")
+	// ErrInvalidField reports a field line that is not a name followed by a
+	// colon and a value, or a blank line within a message.
+	ErrInvalidField = errors.New("invalid field")
+	// ErrUnexpectedContinuation reports a continuation line that does not
+	// follow a field.
+	ErrUnexpectedContinuation = errors.New("continuation line without field")
 )
 
 const (
 	msgMinLineCount = 2
+	statusLength    = 3
 )
 
-// parse splits a string message by line, and then constructs a Message from a
-// Header and slice of Fields.
-func parse(value string) (Message, error) {
-	lines := strings.Split(strings.TrimSpace(value), "\n")
+// A ParseError reports a malformed line of a Message.
+type ParseError struct {
+	// Line is the number of the malformed line, starting at 1.
+	Line int
+	// Text is the content of the line.
+	Text string
+	// Err is the reason the line is malformed, e.g. ErrInvalidField.
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %v: %q", e.Line, e.Err, e.Text)
+}
+
+// Unwrap returns the reason the line is malformed.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// A ParseOption customizes how FromBytes and Decoders parse Messages.
+type ParseOption func(*parser)
+
+// Lenient makes the parser skip malformed field lines instead of failing to
+// parse the Message, passing each one to skipped, which may be nil. A
+// malformed header line still fails, since the rest of the Message cannot be
+// interpreted without it. Without Lenient, parsing is strict and the first
+// malformed line fails.
+func Lenient(skipped func(*ParseError)) ParseOption {
+	return func(p *parser) {
+		p.lenient = true
+		p.skipped = skipped
+	}
+}
+
+// A parser parses the lines of a Message.
+type parser struct {
+	lenient bool
+	skipped func(*ParseError)
+}
+
+func newParser(opts []ParseOption) *parser {
+	p := &parser{}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// parse constructs a Message from a Header line and the Field lines that
+// follow it. Blank lines before and after the Message are ignored. The lines
+// are numbered from firstLine in errors.
+//
+// Like in APT, fields may be repeated, e.g. Config-Item, and lines starting
+// with white space continue the value of the field before them.
+func (p *parser) parse(lines []string, firstLine int) (Message, error) {
+	lines, firstLine = trimBlankLines(lines, firstLine)
 	if len(lines) < msgMinLineCount {
 		return Message{}, errMsgMissingRequiredLines
 	}
-	headerLine := lines[0]
-	fieldLines := lines[1:]
 
-	header, err := parseHeader(headerLine)
+	header, err := parseHeader(lines[0])
+	if err != nil {
+		return Message{}, &ParseError{Line: firstLine, Text: lines[0], Err: err}
+	}
+	fields, err := p.parseFields(lines[1:], firstLine+1)
 	if err != nil {
 		return Message{}, err
 	}
-	fields := parseFields(fieldLines)
-
+	if len(fields) == 0 {
+		return Message{}, errMsgMissingRequiredLines
+	}
 	return Message{Header: header, Fields: fields}, nil
 }
 
-// parseHeader splits a string header by white space and constructs a Header
-// based on the status code and description.
+// trimBlankLines strips carriage returns from the ends of lines and drops the
+// blank lines around a Message, returning the remaining lines and the number
+// of the first one.
+func trimBlankLines(lines []string, firstLine int) ([]string, int) {
+	for i := range lines {
+		lines[i] = strings.TrimSuffix(lines[i], "\r")
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+		firstLine++
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines, firstLine
+}
+
+// parseFields parses the Field lines of a Message, numbered from firstLine in
+// errors. In lenient mode, lines that fail to parse are reported to the
+// skipped callback and dropped.
+func (p *parser) parseFields(lines []string, firstLine int) ([]*Field, error) {
+	fields := []*Field{}
+	for i, line := range lines {
+		err := parseLine(line, &fields)
+		if err == nil {
+			continue
+		}
+		parseErr := &ParseError{Line: firstLine + i, Text: line, Err: err}
+		if !p.lenient {
+			return nil, parseErr
+		}
+		if p.skipped != nil {
+			p.skipped(parseErr)
+		}
+	}
+	return fields, nil
+}
+
+// parseHeader constructs a Header from the status code and description of a
+// header line.
 //
 // Lines might look like the following:
 //
@@ -141,43 +250,61 @@ func parse(value string) (Message, error) {
 // 201 URI Done
 // 601 Configuration
 func parseHeader(line string) (*Header, error) {
-	tokens := strings.Split(strings.TrimSpace(line), " ")
-	status := strings.TrimSpace(tokens[0])
-	statusCode, err := strconv.Atoi(status)
-	if err != nil {
-		return nil, err
+	tokens := strings.Fields(line)
+	if len(tokens) < 2 || len(tokens[0]) != statusLength {
+		return nil, ErrInvalidHeader
 	}
-	descTkns := make([]string, len(tokens[1:]))
-	for idx, descTkn := range tokens[1:] {
-		descTkns[idx] = strings.TrimSpace(descTkn)
+	for _, c := range tokens[0] {
+		if c < '0' || c > '9' {
+			return nil, ErrInvalidHeader
+		}
 	}
-
-	return &Header{Status: statusCode, Description: strings.Join(descTkns, " ")}, nil
-}
-
-func parseFields(lines []string) []*Field {
-	fields := []*Field{}
-	for _, l := range lines {
-		fields = append(fields, parseField(l))
+	statusCode, err := strconv.Atoi(tokens[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidHeader, err)
 	}
-	return fields
+	description := strings.Join(tokens[1:], " ")
+	if !isPrintable(description) {
+		return nil, ErrInvalidHeader
+	}
+	return &Header{Status: statusCode, Description: description}, nil
 }
 
-// parseField splits a string field by colon and constructs a Field based on
-// the name and value.
+// parseLine parses a field line, either appending a new Field to fields or
+// continuing the value of the last one.
 //
 // Lines might look like the following:
 //
 // URI:s3://my-s3-repository/project-a/dists/trusty/main/binary-amd64/Packages
 // Config-Item: Aptitude::Get-Root-Command=sudo:/usr/bin/sudo
-func parseField(line string) *Field {
-	tokens := strings.Split(strings.TrimSpace(line), ":")
+func parseLine(line string, fields *[]*Field) error {
+	if line == "" {
+		return ErrInvalidField
+	}
+	if line[0] == ' ' || line[0] == '\t' {
+		if len(*fields) == 0 {
+			return ErrUnexpectedContinuation
+		}
+		last := (*fields)[len(*fields)-1]
+		last.Value += "\n" + strings.TrimSpace(line)
+		return nil
+	}
 
-	// The line may have additional colons, so the value needs to be any tokens
-	// after the first joined with a colon.
-	valueTkns := make([]string, len(tokens[1:]))
-	for idx, valueTkn := range tokens[1:] {
-		valueTkns[idx] = strings.TrimSpace(valueTkn)
+	// The value may contain additional colons, so the line is only split on
+	// the first one.
+	idx := strings.Index(line, ":")
+	if idx < 0 {
+		return ErrInvalidField
 	}
-	return &Field{Name: tokens[0], Value: strings.Join(valueTkns, ":")}
+	name := strings.TrimSpace(line[:idx])
+	if name == "" || strings.IndexFunc(name, unicode.IsSpace) >= 0 || !isPrintable(name) {
+		return ErrInvalidField
+	}
+	*fields = append(*fields, &Field{Name: name, Value: strings.TrimSpace(line[idx+1:])})
+	return nil
+}
+
+// isPrintable reports whether s is made up of printable characters only.
+func isPrintable(s string) bool {
+	return strings.IndexFunc(s, func(r rune) bool { return !unicode.IsPrint(r) }) < 0
 }
@@ -15,7 +15,10 @@
 package message
 
 import (
+	"errors"
 	"testing"
+
+	"github.com/google/go-cmp/cmp"
 )
 
 const (
@@ -181,3 +184,121 @@ func TestParseFieldsWithMissingSpaces(t *testing.T) {
 		t.Errorf("field.Value = %s; expected %s", field.Value, expectedVal)
 	}
 }
+
+func TestParseContinuationAndDuplicateFields(t *testing.T) {
+	msg, err := FromBytes([]byte("601 Configuration\r\nConfig-Item: Dir=/\r\nconfig-item: Dir::Etc=etc/apt/\n" +
+		"Message: first line\n  second line\n\tthird line\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count := len(msg.GetFieldList("Config-Item")); count != 2 {
+		t.Errorf("len(GetFieldList(Config-Item)) = %d; expected %d", count, 2)
+	}
+	if value, _ := msg.GetFieldValue("CONFIG-ITEM"); value != "Dir=/" {
+		t.Errorf("GetFieldValue(CONFIG-ITEM) = %q; expected the first field %q", value, "Dir=/")
+	}
+	expected := "first line\nsecond line\nthird line"
+	if value, _ := msg.GetFieldValue("Message"); value != expected {
+		t.Errorf("GetFieldValue(Message) = %q; expected %q", value, expected)
+	}
+	if actual, expectedLine := msg.Fields[2].String(), "Message: first line\n second line\n third line"; actual != expectedLine {
+		t.Errorf("Fields[2].String() = %q; expected %q", actual, expectedLine)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	specs := map[string]struct {
+		input        string
+		expectedLine int
+		expectedErr  error
+	}{
+		"status not a number":   {"abc URI Acquire\nURI: s3://bucket/key\n", 1, ErrInvalidHeader},
+		"status too long":       {"6000 URI Acquire\nURI: s3://bucket/key\n", 1, ErrInvalidHeader},
+		"missing description":   {"600\nURI: s3://bucket/key\n", 1, ErrInvalidHeader},
+		"garbage description":   {"600 URI\x00Acquire\nURI: s3://bucket/key\n", 1, ErrInvalidHeader},
+		"missing colon":         {"\n600 URI Acquire\nURI: s3://bucket/key\nFilename\n", 4, ErrInvalidField},
+		"empty name":            {"600 URI Acquire\n: s3://bucket/key\n", 2, ErrInvalidField},
+		"name with white space": {"600 URI Acquire\nFile name: key\n", 2, ErrInvalidField},
+		"blank line":            {"600 URI Acquire\nURI: s3://bucket/key\n\nFilename: key\n", 3, ErrInvalidField},
+		"orphan continuation":   {"600 URI Acquire\n URI: s3://bucket/key\n", 2, ErrUnexpectedContinuation},
+	}
+
+	for name, spec := range specs {
+		t.Run(name, func(t *testing.T) {
+			_, err := FromBytes([]byte(spec.input))
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("FromBytes() error = %v; expected a *ParseError", err)
+			}
+			if parseErr.Line != spec.expectedLine {
+				t.Errorf("ParseError.Line = %d; expected %d", parseErr.Line, spec.expectedLine)
+			}
+			if !errors.Is(err, spec.expectedErr) {
+				t.Errorf("FromBytes() error = %v; expected %v", err, spec.expectedErr)
+			}
+		})
+	}
+}
+
+func TestParseLenient(t *testing.T) {
+	input := "600 URI Acquire\n continued\nURI: s3://bucket/key\nFilename\nFilename: key\n"
+	var skipped []int
+	msg, err := FromBytes([]byte(input), Lenient(func(err *ParseError) {
+		skipped = append(skipped, err.Line)
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]int{2, 4}, skipped); diff != "" {
+		t.Errorf("skipped lines mismatch (-expected +actual):\n%s", diff)
+	}
+	expected := "600 URI Acquire\nURI: s3://bucket/key\nFilename: key\n"
+	if actual := msg.String(); actual != expected {
+		t.Errorf("FromBytes() = %s; expected %s", actual, expected)
+	}
+
+	// A malformed header fails in lenient mode too.
+	if _, err := FromBytes([]byte("URI Acquire\nURI: s3://bucket/key\n"), Lenient(nil)); !errors.Is(err, ErrInvalidHeader) {
+		t.Errorf("FromBytes() error = %v; expected %v", err, ErrInvalidHeader)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	// FuzzFromBytes needs Go 1.18, so its seed corpus is also checked here.
+	for _, seed := range roundTripSeeds() {
+		checkRoundTrip(t, []byte(seed))
+	}
+}
+
+// roundTripSeeds returns the inputs that checkRoundTrip is run on, and that
+// FuzzFromBytes starts from.
+func roundTripSeeds() []string {
+	return []string{
+		fakeMsg,
+		configMsg,
+		acqMsg,
+		acqMsgNoSpaces,
+		"201 URI Done\nURI: s3://bucket/key\nMessage: first line\n second line\n",
+		"600 URI Acquire\n\nURI: s3://bucket/key\n",
+		"600\n: \n\t\n",
+	}
+}
+
+// checkRoundTrip checks that whatever parses, strictly or leniently, survives
+// being written and parsed again, strictly, unchanged.
+func checkRoundTrip(t *testing.T, b []byte) {
+	t.Helper()
+	for _, opts := range [][]ParseOption{nil, {Lenient(nil)}} {
+		msg, err := FromBytes(b, opts...)
+		if err != nil {
+			continue
+		}
+		reparsed, err := FromBytes([]byte(msg.String()))
+		if err != nil {
+			t.Fatalf("FromBytes(%q) = %v; expected the output of String() to parse", msg.String(), err)
+		}
+		if reparsed.String() != msg.String() {
+			t.Errorf("FromBytes(%q).String() = %q; expected %q", msg.String(), reparsed.String(), msg.String())
+		}
+	}
+}
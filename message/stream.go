@@ -0,0 +1,119 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package message
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// maxLineLength is the length of the longest line a Decoder accepts.
+const maxLineLength = 1024 * 1024
+
+// A DecodeError reports a Message read by a Decoder that could not be parsed.
+type DecodeError struct {
+	// Line is the number of the line of the input, starting at 1, that the
+	// Message starts on.
+	Line int
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("message at line %d: %v", e.Line, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// A Decoder reads successive Messages from an input stream. Messages are
+// terminated by a blank line; any blank lines in between Messages are
+// skipped.
+type Decoder struct {
+	scanner *bufio.Scanner
+	parser  *parser
+	// line is the number of lines read so far.
+	line int
+}
+
+// NewDecoder returns a Decoder that reads Messages from r, parsing them with
+// the given options. Line numbers in a *ParseError count from the start of r.
+func NewDecoder(r io.Reader, opts ...ParseOption) *Decoder {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineLength)
+	return &Decoder{scanner: scanner, parser: newParser(opts)}
+}
+
+// Decode reads the next Message from the input. It returns io.EOF once the
+// input ends after a complete Message. If the input ends in the middle of a
+// Message, the error wraps io.ErrUnexpectedEOF. Messages that cannot be
+// parsed are reported as a *DecodeError, after which Decode can be called
+// again to read the Message that follows.
+func (d *Decoder) Decode() (*Message, error) {
+	lines, start, err := d.readLines()
+	if err != nil {
+		return nil, err
+	}
+	msg, err := d.parser.parse(lines, start)
+	if err != nil {
+		return nil, &DecodeError{Line: start, Err: err}
+	}
+	return &msg, nil
+}
+
+// readLines reads the lines of the next Message up to the blank line that ends
+// it, skipping blank lines before it, and returns them along with the number
+// of the first one.
+func (d *Decoder) readLines() ([]string, int, error) {
+	var lines []string
+	start := 0
+	for d.scanner.Scan() {
+		d.line++
+		line := d.scanner.Text()
+		if line != "" {
+			if len(lines) == 0 {
+				start = d.line
+			}
+			lines = append(lines, line)
+		} else if len(lines) > 0 {
+			return lines, start, nil
+		}
+	}
+	if err := d.scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("line %d: %w", d.line+1, err)
+	}
+	if len(lines) > 0 {
+		return nil, 0, &DecodeError{Line: start, Err: io.ErrUnexpectedEOF}
+	}
+	return nil, 0, io.EOF
+}
+
+// An Encoder writes Messages to an output stream.
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder returns an Encoder that writes Messages to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes msg, followed by the blank line that terminates it.
+func (e *Encoder) Encode(msg *Message) error {
+	_, err := io.WriteString(e.w, msg.String()+"\n")
+	return err
+}
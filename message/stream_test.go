@@ -0,0 +1,140 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package message
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	input := "\n" + configMsg + "\n" + acqMsg + "\n\n" + acqMsgNoSpaces + "\n"
+	decoder := NewDecoder(strings.NewReader(input))
+
+	for _, expected := range []string{configMsg, acqMsg, acqMsgNoSpaces} {
+		msg, err := decoder.Decode()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expectedMsg, err := FromBytes([]byte(expected))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if msg.String() != expectedMsg.String() {
+			t.Errorf("Decode() = %s; expected %s", msg, expectedMsg)
+		}
+	}
+	if msg, err := decoder.Decode(); !errors.Is(err, io.EOF) {
+		t.Errorf("Decode() = %v, %v; expected %v", msg, err, io.EOF)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	specs := map[string]struct {
+		input        string
+		expectedLine int
+		expectedErr  error
+	}{
+		"missing fields": {
+			acqMsg + "\n\n600 URI Acquire\n\n",
+			6,
+			errMsgMissingRequiredLines,
+		},
+		"truncated": {
+			acqMsg + "\n" + "600 URI Acquire\nURI: s3://bucket/key\n",
+			5,
+			io.ErrUnexpectedEOF,
+		},
+	}
+
+	for name, spec := range specs {
+		t.Run(name, func(t *testing.T) {
+			decoder := NewDecoder(strings.NewReader(spec.input))
+			if _, err := decoder.Decode(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			_, err := decoder.Decode()
+			var decodeErr *DecodeError
+			if !errors.As(err, &decodeErr) {
+				t.Fatalf("Decode() error = %v; expected a *DecodeError", err)
+			}
+			if decodeErr.Line != spec.expectedLine {
+				t.Errorf("DecodeError.Line = %d; expected %d", decodeErr.Line, spec.expectedLine)
+			}
+			if !errors.Is(err, spec.expectedErr) {
+				t.Errorf("Decode() error = %v; expected %v", err, spec.expectedErr)
+			}
+		})
+	}
+}
+
+func TestDecodeInvalidHeader(t *testing.T) {
+	decoder := NewDecoder(strings.NewReader("URI Acquire\nURI: s3://bucket/key\n\n"))
+	_, err := decoder.Decode()
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Line != 1 {
+		t.Errorf("Decode() error = %v; expected a *DecodeError for line 1", err)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	buf := &bytes.Buffer{}
+	encoder := NewEncoder(buf)
+	for _, value := range []string{fakeMsg, acqMsg} {
+		msg, err := FromBytes([]byte(value))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := encoder.Encode(msg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	expected := fakeMsg + "\n" + acqMsg + "\n"
+	if buf.String() != expected {
+		t.Errorf("Encode() wrote %q; expected %q", buf.String(), expected)
+	}
+
+	// Encoded messages can be decoded again.
+	decoder := NewDecoder(buf)
+	for _, value := range []string{fakeMsg, acqMsg} {
+		msg, err := decoder.Decode()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if msg.String() != value {
+			t.Errorf("Decode() = %q; expected %q", msg.String(), value)
+		}
+	}
+}
+
+func TestDecodeLenient(t *testing.T) {
+	input := acqMsg + "\n600 URI Acquire\nURI: s3://bucket/key\nFilename\nFilename: key\n\n"
+	var skipped []*ParseError
+	decoder := NewDecoder(strings.NewReader(input), Lenient(func(err *ParseError) {
+		skipped = append(skipped, err)
+	}))
+	for i := 0; i < 2; i++ {
+		if _, err := decoder.Decode(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if len(skipped) != 1 || skipped[0].Line != 7 || !errors.Is(skipped[0], ErrInvalidField) {
+		t.Errorf("skipped = %v; expected an invalid field at line 7", skipped)
+	}
+}
@@ -0,0 +1,446 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package message
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Status codes of the messages of the APT method interface.
+const (
+	StatusCapabilities   = 100
+	StatusLog            = 101
+	StatusStatus         = 102
+	StatusURIStart       = 200
+	StatusURIDone        = 201
+	StatusURIFailure     = 400
+	StatusGeneralFailure = 401
+	StatusURIAcquire     = 600
+	StatusConfiguration  = 601
+)
+
+var (
+	// ErrMissingField is returned by Unmarshal for a Message that lacks a
+	// required field.
+	ErrMissingField = errors.New("missing required field")
+	// ErrUnexpectedHeader is returned by Unmarshal for a Message of a type
+	// other than the one being unmarshaled into.
+	ErrUnexpectedHeader = errors.New("unexpected message header")
+	// ErrUnsupportedType is returned by Marshal and Unmarshal for values that
+	// are not structs or have tagged fields of unsupported types.
+	ErrUnsupportedType = errors.New("unsupported type")
+	// ErrInvalidValue is returned by Unmarshal for a required field whose value
+	// cannot be parsed into the type of the struct field.
+	ErrInvalidValue = errors.New("invalid value")
+)
+
+// A Typed message is one of the messages of the APT method interface as a Go
+// struct, which Marshal and Unmarshal convert to and from a Message.
+//
+// The fields of the Message are described by struct tags of the form
+//
+//	message:"Name[,option]..."
+//
+// where the options are "required", for fields that must be present in the
+// Message, and "yesno", for bool fields that are written as "yes" rather than
+// "true". Fields of type string, bool, int, int64 and time.Time hold a single
+// field of the Message, fields of type []string hold all fields with the name.
+// Fields that are not required are left out of the Message if they have their
+// zero value.
+type Typed interface {
+	// Header returns the Header of messages of the type.
+	Header() Header
+}
+
+// Capabilities is sent by a method when it starts, e.g.
+//
+// 100 Capabilities
+// Send-Config: true
+// Pipeline: true
+// Single-Instance: yes
+type Capabilities struct {
+	Version        string `message:"Version"`
+	SendConfig     bool   `message:"Send-Config"`
+	Pipeline       bool   `message:"Pipeline"`
+	SingleInstance bool   `message:"Single-Instance,yesno"`
+	LocalOnly      bool   `message:"Local-Only"`
+	NeedsCleanup   bool   `message:"Needs-Cleanup"`
+	Removable      bool   `message:"Removable"`
+}
+
+// Header implements Typed.
+func (Capabilities) Header() Header {
+	return Header{Status: StatusCapabilities, Description: "Capabilities"}
+}
+
+// Log is sent by a method to log a message, e.g.
+//
+// 101 Log
+// Message: Discovered region eu-west-1 for bucket my-bucket
+type Log struct {
+	Message string `message:"Message,required"`
+}
+
+// Header implements Typed.
+func (Log) Header() Header {
+	return Header{Status: StatusLog, Description: "Log"}
+}
+
+// Status is sent by a method to report progress on a URI, e.g.
+//
+// 102 Status
+// URI: s3://my-bucket/dists/stable/Release
+// Message: Connecting to s3.amazonaws.com
+type Status struct {
+	URI     string `message:"URI,required"`
+	Message string `message:"Message,required"`
+}
+
+// Header implements Typed.
+func (Status) Header() Header {
+	return Header{Status: StatusStatus, Description: "Status"}
+}
+
+// URIStart is sent by a method when it starts downloading a URI, e.g.
+//
+// 200 URI Start
+// URI: s3://my-bucket/pool/main/a/apt/apt_2.6.1_amd64.deb
+// Size: 9012
+// Last-Modified: Thu, 25 Oct 2018 20:17:39 GMT
+// Resume-Point: 4096
+type URIStart struct {
+	URI          string    `message:"URI,required"`
+	Size         int64     `message:"Size,required"`
+	LastModified time.Time `message:"Last-Modified"`
+	ResumePoint  int64     `message:"Resume-Point"`
+}
+
+// Header implements Typed.
+func (URIStart) Header() Header {
+	return Header{Status: StatusURIStart, Description: "URI Start"}
+}
+
+// URIDone is sent by a method when it has acquired a URI, e.g.
+//
+// 201 URI Done
+// URI: s3://my-bucket/pool/main/a/apt/apt_2.6.1_amd64.deb
+// Filename: /var/cache/apt/archives/partial/apt_2.6.1_amd64.deb
+// Size: 9012
+// Last-Modified: Thu, 25 Oct 2018 20:17:39 GMT
+// SHA256-Hash: 92a3f70eb1cf2c69880988a8e74dc6fea7e4f15ee261f74b9be55c866f69c64b
+type URIDone struct {
+	URI          string    `message:"URI,required"`
+	Filename     string    `message:"Filename,required"`
+	Size         int64     `message:"Size"`
+	LastModified time.Time `message:"Last-Modified"`
+	MD5Hash      string    `message:"MD5-Hash"`
+	MD5SumHash   string    `message:"MD5Sum-Hash"`
+	SHA1Hash     string    `message:"SHA1-Hash"`
+	SHA256Hash   string    `message:"SHA256-Hash"`
+	SHA512Hash   string    `message:"SHA512-Hash"`
+	IMSHit       bool      `message:"IMS-Hit"`
+}
+
+// Header implements Typed.
+func (URIDone) Header() Header {
+	return Header{Status: StatusURIDone, Description: "URI Done"}
+}
+
+// URIFailure is sent by a method when it could not acquire a URI, e.g.
+//
+// 400 URI Failure
+// URI: s3://my-bucket/dists/stable/InRelease
+// Message: The specified key does not exist.
+// FailReason: HttpError404
+type URIFailure struct {
+	URI              string `message:"URI,required"`
+	Message          string `message:"Message,required"`
+	FailReason       string `message:"FailReason"`
+	TransientFailure bool   `message:"Transient-Failure"`
+}
+
+// Header implements Typed.
+func (URIFailure) Header() Header {
+	return Header{Status: StatusURIFailure, Description: "URI Failure"}
+}
+
+// GeneralFailure is sent by a method when it cannot continue, e.g.
+//
+// 401 General Failure
+// Message: reading input: unexpected EOF
+type GeneralFailure struct {
+	Message string `message:"Message,required"`
+}
+
+// Header implements Typed.
+func (GeneralFailure) Header() Header {
+	return Header{Status: StatusGeneralFailure, Description: "General Failure"}
+}
+
+// URIAcquire is sent by APT to request a URI, e.g.
+//
+// 600 URI Acquire
+// URI: s3://my-bucket/dists/stable/Release
+// Filename: /var/lib/apt/lists/partial/my-bucket_dists_stable_Release
+// Last-Modified: Thu, 25 Oct 2018 20:17:39 GMT
+// Expected-SHA256: 92a3f70eb1cf2c69880988a8e74dc6fea7e4f15ee261f74b9be55c866f69c64b
+type URIAcquire struct {
+	URI            string    `message:"URI,required"`
+	Filename       string    `message:"Filename,required"`
+	LastModified   time.Time `message:"Last-Modified"`
+	IndexFile      bool      `message:"Index-File"`
+	FailIgnore     bool      `message:"Fail-Ignore"`
+	MaximumSize    int64     `message:"Maximum-Size"`
+	ExpectedSHA512 string    `message:"Expected-SHA512"`
+	ExpectedSHA256 string    `message:"Expected-SHA256"`
+	ExpectedSHA1   string    `message:"Expected-SHA1"`
+	ExpectedMD5Sum string    `message:"Expected-MD5Sum"`
+}
+
+// Header implements Typed.
+func (URIAcquire) Header() Header {
+	return Header{Status: StatusURIAcquire, Description: "URI Acquire"}
+}
+
+// Configuration is sent by APT to pass its configuration to a method, e.g.
+//
+// 601 Configuration
+// Config-Item: Acquire::s3::region=us-east-2
+// Config-Item: Dir::Etc=etc/apt/
+type Configuration struct {
+	ConfigItems []string `message:"Config-Item"`
+}
+
+// Header implements Typed.
+func (Configuration) Header() Header {
+	return Header{Status: StatusConfiguration, Description: "Configuration"}
+}
+
+// fieldTag is the parsed struct tag of a field of a Typed message.
+type fieldTag struct {
+	name            string
+	required, yesno bool
+}
+
+// parseTag parses the message struct tag of the given field. It reports false
+// for fields without one.
+func parseTag(f reflect.StructField) (fieldTag, bool) {
+	value, ok := f.Tag.Lookup("message")
+	if !ok || value == "-" {
+		return fieldTag{}, false
+	}
+	tokens := strings.Split(value, ",")
+	tag := fieldTag{name: tokens[0]}
+	for _, option := range tokens[1:] {
+		switch option {
+		case "required":
+			tag.required = true
+		case "yesno":
+			tag.yesno = true
+		}
+	}
+	return tag, true
+}
+
+//nolint:gochecknoglobals
+var timeType = reflect.TypeOf(time.Time{})
+
+// Marshal converts v, a Typed message or a pointer to one, to a Message.
+func Marshal(v Typed) (*Message, error) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("marshaling %T: %w", v, ErrUnsupportedType)
+	}
+	header := v.Header()
+	msg := &Message{Header: &header, Fields: []*Field{}}
+	for i := 0; i < rv.NumField(); i++ {
+		tag, ok := parseTag(rv.Type().Field(i))
+		if !ok {
+			continue
+		}
+		field := rv.Field(i)
+		if field.IsZero() && !tag.required {
+			continue
+		}
+		values, err := marshalValue(field, tag)
+		if err != nil {
+			return nil, fmt.Errorf("marshaling %s field %s: %w", header.Description, tag.name, err)
+		}
+		for _, value := range values {
+			msg.Fields = append(msg.Fields, &Field{Name: tag.name, Value: value})
+		}
+	}
+	return msg, nil
+}
+
+// marshalValue returns the values of the Message fields for a struct field.
+func marshalValue(v reflect.Value, tag fieldTag) ([]string, error) {
+	if v.Type() == timeType {
+		t, _ := v.Interface().(time.Time)
+		return []string{t.UTC().Format(http.TimeFormat)}, nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return []string{v.String()}, nil
+	case reflect.Bool:
+		return []string{formatBool(v.Bool(), tag.yesno)}, nil
+	case reflect.Int, reflect.Int64:
+		return []string{strconv.FormatInt(v.Int(), 10)}, nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.String {
+			values := make([]string, v.Len())
+			for i := range values {
+				values[i] = v.Index(i).String()
+			}
+			return values, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: %w", v.Type(), ErrUnsupportedType)
+}
+
+// formatBool formats b as "true" or "false", or as "yes" or "no" for fields
+// tagged yesno.
+func formatBool(b, yesno bool) string {
+	switch {
+	case !yesno:
+		return strconv.FormatBool(b)
+	case b:
+		return "yes"
+	default:
+		return "no"
+	}
+}
+
+// Unmarshal stores the fields of msg in v, which must be a pointer to a Typed
+// message of the same type as msg. Fields of msg that v has no struct field
+// for are ignored.
+//
+// All fields present in msg are stored before an error for missing required
+// fields is returned, so that e.g. a URI Failure can still be reported for the
+// URI of a URI Acquire message that lacks a Filename. Optional fields are
+// best effort: a value that does not parse, e.g. a Last-Modified time in an
+// unknown format, is ignored as if the field were absent. Only values of
+// required fields that do not parse are an error.
+func Unmarshal(msg *Message, v Typed) error {
+	rv, err := unmarshalTarget(msg, v)
+	if err != nil {
+		return err
+	}
+
+	header := v.Header()
+	var missing []string
+	for i := 0; i < rv.NumField(); i++ {
+		tag, ok := parseTag(rv.Type().Field(i))
+		if !ok {
+			continue
+		}
+		found, err := unmarshalField(msg, rv.Field(i), tag)
+		if err != nil {
+			return fmt.Errorf("unmarshaling %s field %s: %w", header.Description, tag.name, err)
+		}
+		if !found && tag.required {
+			missing = append(missing, tag.name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s message %w: %s", header.Description, ErrMissingField, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// unmarshalTarget returns the struct v points to, after checking that msg is
+// a message of v's type.
+func unmarshalTarget(msg *Message, v Typed) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("unmarshaling into %T: %w", v, ErrUnsupportedType)
+	}
+	header := v.Header()
+	if msg.Header == nil || msg.Header.Status != header.Status {
+		return reflect.Value{}, fmt.Errorf("unmarshaling %s message: %w: %v", header.Description, ErrUnexpectedHeader, msg.Header)
+	}
+	return rv.Elem(), nil
+}
+
+// unmarshalField stores the Message fields named by tag in the struct field v
+// and reports whether msg has any. Values of optional fields that do not
+// parse are ignored.
+func unmarshalField(msg *Message, v reflect.Value, tag fieldTag) (bool, error) {
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String {
+		values := []string{}
+		for _, f := range msg.GetFieldList(tag.name) {
+			values = append(values, f.Value)
+		}
+		v.Set(reflect.ValueOf(values).Convert(v.Type()))
+		return true, nil
+	}
+	value, hasField := msg.GetFieldValue(tag.name)
+	if !hasField {
+		return false, nil
+	}
+	err := unmarshalValue(v, value)
+	if err != nil && (tag.required || errors.Is(err, ErrUnsupportedType)) {
+		return true, err
+	}
+	return true, nil
+}
+
+// unmarshalValue parses value into the struct field v.
+func unmarshalValue(v reflect.Value, value string) error {
+	if v.Type() == timeType {
+		t, err := http.ParseTime(value)
+		if err != nil {
+			return fmt.Errorf("%w for time: %q", ErrInvalidValue, value)
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := parseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w for integer: %q", ErrInvalidValue, value)
+		}
+		v.SetInt(n)
+	default:
+		return fmt.Errorf("%s: %w", v.Type(), ErrUnsupportedType)
+	}
+	return nil
+}
+
+// parseBool parses the boolean values used by APT.
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "true", "yes", "1":
+		return true, nil
+	case "false", "no", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("%w for boolean: %q", ErrInvalidValue, value)
+}
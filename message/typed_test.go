@@ -0,0 +1,196 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package message
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMarshal(t *testing.T) {
+	lastModified := time.Date(2018, time.October, 25, 20, 17, 39, 0, time.UTC)
+	specs := map[string]struct {
+		value    Typed
+		expected string
+	}{
+		"capabilities": {
+			Capabilities{SendConfig: true, Pipeline: true, SingleInstance: true},
+			"100 Capabilities\nSend-Config: true\nPipeline: true\nSingle-Instance: yes\n",
+		},
+		"required fields are kept": {
+			URIStart{URI: "s3://bucket/key"},
+			"200 URI Start\nURI: s3://bucket/key\nSize: 0\n",
+		},
+		"optional fields": {
+			&URIStart{URI: "s3://bucket/key", Size: 9012, LastModified: lastModified, ResumePoint: 4096},
+			"200 URI Start\nURI: s3://bucket/key\nSize: 9012\nLast-Modified: Thu, 25 Oct 2018 20:17:39 GMT\nResume-Point: 4096\n",
+		},
+		"time in GMT": {
+			URIDone{URI: "s3://bucket/key", Filename: "key", LastModified: lastModified.In(time.FixedZone("PDT", -7*60*60)), IMSHit: true},
+			"201 URI Done\nURI: s3://bucket/key\nFilename: key\nLast-Modified: Thu, 25 Oct 2018 20:17:39 GMT\nIMS-Hit: true\n",
+		},
+		"repeated fields": {
+			Configuration{ConfigItems: []string{"Acquire::s3::region=us-east-2", "Dir::Etc=etc/apt/"}},
+			"601 Configuration\nConfig-Item: Acquire::s3::region=us-east-2\nConfig-Item: Dir::Etc=etc/apt/\n",
+		},
+	}
+
+	for name, spec := range specs {
+		t.Run(name, func(t *testing.T) {
+			msg, err := Marshal(spec.value)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual := msg.String(); actual != spec.expected {
+				t.Errorf("Marshal() = %s; expected %s", actual, spec.expected)
+			}
+		})
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	msg, err := FromBytes([]byte(acqMsgNoSpaces + "Last-Modified: Thu, 25 Oct 2018 20:17:39 GMT\nExpected-SHA256: abc\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var actual URIAcquire
+	if err := Unmarshal(msg, &actual); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := URIAcquire{
+		URI:            "s3://my-s3-repository/project-a/dists/trusty/main/binary-amd64/Packages",
+		Filename:       "Packages.downloaded",
+		LastModified:   time.Date(2018, time.October, 25, 20, 17, 39, 0, time.UTC),
+		IndexFile:      true,
+		FailIgnore:     true,
+		ExpectedSHA256: "abc",
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("Unmarshal() mismatch (-expected +actual):\n%s", diff)
+	}
+}
+
+func TestUnmarshalConfiguration(t *testing.T) {
+	msg, err := FromBytes([]byte(configMsg))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var actual Configuration
+	if err := Unmarshal(msg, &actual); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(actual.ConfigItems) != len(msg.Fields) {
+		t.Errorf("len(ConfigItems) = %d; expected %d", len(actual.ConfigItems), len(msg.Fields))
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	specs := map[string]struct {
+		input       string
+		expectedURI string
+		expectedErr error
+	}{
+		"missing required field": {
+			"600 URI Acquire\nURI: s3://bucket/key\n",
+			"s3://bucket/key",
+			ErrMissingField,
+		},
+		"unexpected header": {
+			"601 Configuration\nConfig-Item: Dir=/\n",
+			"",
+			ErrUnexpectedHeader,
+		},
+	}
+
+	for name, spec := range specs {
+		t.Run(name, func(t *testing.T) {
+			msg, err := FromBytes([]byte(spec.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var req URIAcquire
+			err = Unmarshal(msg, &req)
+			if err == nil {
+				t.Fatalf("Unmarshal() = nil; expected an error")
+			}
+			if spec.expectedErr != nil && !errors.Is(err, spec.expectedErr) {
+				t.Errorf("Unmarshal() = %v; expected %v", err, spec.expectedErr)
+			}
+			if req.URI != spec.expectedURI {
+				t.Errorf("URI = %q; expected %q", req.URI, spec.expectedURI)
+			}
+		})
+	}
+}
+
+func TestUnmarshalInvalidOptionalValues(t *testing.T) {
+	msg, err := FromBytes([]byte("600 URI Acquire\nURI: s3://bucket/key\nFilename: key\n" +
+		"Last-Modified: yesterday\nIndex-File: maybe\nFail-Ignore: yes\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var actual URIAcquire
+	if err := Unmarshal(msg, &actual); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := URIAcquire{URI: "s3://bucket/key", Filename: "key", FailIgnore: true}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("Unmarshal() mismatch (-expected +actual):\n%s", diff)
+	}
+}
+
+func TestUnmarshalValueErrors(t *testing.T) {
+	var (
+		boolValue bool
+		intValue  int64
+		timeValue time.Time
+	)
+	specs := map[string]struct {
+		v     interface{}
+		value string
+	}{
+		"boolean": {&boolValue, "maybe"},
+		"integer": {&intValue, "many"},
+		"time":    {&timeValue, "yesterday"},
+	}
+
+	for name, spec := range specs {
+		t.Run(name, func(t *testing.T) {
+			err := unmarshalValue(reflect.ValueOf(spec.v).Elem(), spec.value)
+			if !errors.Is(err, ErrInvalidValue) {
+				t.Errorf("unmarshalValue(%q) = %v; expected %v", spec.value, err, ErrInvalidValue)
+			}
+		})
+	}
+}
+
+func TestUnmarshalRoundTrip(t *testing.T) {
+	expected := URIFailure{URI: "s3://bucket/key", Message: "The specified key does not exist.", FailReason: "HttpError404"}
+	msg, err := Marshal(expected)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var actual URIFailure
+	if err := Unmarshal(msg, &actual); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("Unmarshal(Marshal()) mismatch (-expected +actual):\n%s", diff)
+	}
+}
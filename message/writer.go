@@ -0,0 +1,67 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package message
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+// A Writer writes Messages to an underlying io.Writer. It is safe for
+// concurrent use; each Message is written and flushed as a whole, so Messages
+// written from different goroutines are never interleaved.
+//
+// Once a write fails, the Writer keeps returning the same error for every
+// subsequent write.
+type Writer struct {
+	mu  sync.Mutex
+	w   *bufio.Writer
+	enc *Encoder
+	err error
+}
+
+// NewWriter returns a Writer that writes Messages to w.
+func NewWriter(w io.Writer) *Writer {
+	buffered := bufio.NewWriter(w)
+	return &Writer{w: buffered, enc: NewEncoder(buffered)}
+}
+
+// WriteMessage writes msg, followed by the blank line that terminates it, and
+// flushes it to the underlying io.Writer.
+func (w *Writer) WriteMessage(msg *Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.enc.Encode(msg); err != nil {
+		w.err = err
+		return err
+	}
+	if err := w.w.Flush(); err != nil {
+		w.err = err
+		return err
+	}
+	return nil
+}
+
+// Err returns the first error encountered while writing, if any.
+func (w *Writer) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package message
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWriteMessage(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	msg, err := FromBytes([]byte(fakeMsg))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := w.WriteMessage(msg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := fakeMsg + "\n"
+	if buf.String() != expected {
+		t.Errorf("WriteMessage() wrote %q; expected %q", buf.String(), expected)
+	}
+}
+
+func TestWriteMessageConcurrently(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	msg, err := FromBytes([]byte(fakeMsg))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	count := 50
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.WriteMessage(msg); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	expected := strings.Repeat(fakeMsg+"\n", count)
+	if buf.String() != expected {
+		t.Errorf("Found interleaved messages: %q", buf.String())
+	}
+}
+
+type failingWriter struct{}
+
+var errBrokenPipe = errors.New("broken pipe")
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errBrokenPipe
+}
+
+func TestWriteMessageError(t *testing.T) {
+	w := NewWriter(failingWriter{})
+	msg, err := FromBytes([]byte(fakeMsg))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := w.WriteMessage(msg); !errors.Is(err, errBrokenPipe) {
+			t.Errorf("WriteMessage() = %v; expected %v", err, errBrokenPipe)
+		}
+	}
+	if !errors.Is(w.Err(), errBrokenPipe) {
+		t.Errorf("Err() = %v; expected %v", w.Err(), errBrokenPipe)
+	}
+}
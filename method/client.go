@@ -0,0 +1,237 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package method
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// A clientKey identifies everything that goes into building an S3 client.
+// Acquires that share a clientKey share a client, and with it the session,
+// HTTP connections and any assumed-role credentials.
+type clientKey struct {
+	accessKeyID, secretAccessKey                     string
+	role                                             roleConfig
+	profile, sharedConfigFile, sharedCredentialsFile string
+	region, endpoint                                 string
+	forcePathStyle, disableSSL                       bool
+	endpointOptions                                  endpointOptions
+	transport                                        *http.Transport
+}
+
+// A clientCache builds S3 clients on first use and reuses them for the
+// lifetime of the Method.
+type clientCache struct {
+	build func(key clientKey) (s3iface.S3API, error)
+
+	mu      sync.Mutex
+	clients map[clientKey]s3iface.S3API
+}
+
+func newClientCache(build func(key clientKey) (s3iface.S3API, error)) *clientCache {
+	return &clientCache{
+		build:   build,
+		clients: map[clientKey]s3iface.S3API{},
+	}
+}
+
+// get returns the client for key, building it if necessary. Building a client
+// does not make any requests, so the lock is held while doing so to make sure
+// each client is only ever built once.
+func (cache *clientCache) get(key clientKey) (s3iface.S3API, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if client, ok := cache.clients[key]; ok {
+		return client, nil
+	}
+	client, err := cache.build(key)
+	if err != nil {
+		return nil, err
+	}
+	cache.clients[key] = client
+	return client, nil
+}
+
+// s3Client provides an initialized s3iface.S3API based on the given config and
+// the contents of the provided url.Userinfo. The access key id and secret
+// access key are assumed to correspond to the Username() and Password()
+// functions on the URL's User.
+func (method *Method) s3Client(cfg *config, user *url.Userinfo) (s3iface.S3API, error) {
+	s3URL, err := cfg.endpointURL()
+	if err != nil {
+		return nil, err
+	}
+	key := endpointClientKey(cfg, s3URL)
+	if cfg.transports != nil {
+		if key.transport, err = cfg.transports.get(s3URL.Hostname(), cfg.tlsFor(s3URL.Hostname())); err != nil {
+			return nil, err
+		}
+	}
+	if accessKeyID := user.Username(); accessKeyID != "" {
+		// Use explicitly specified static credentials to access S3
+		secretAccessKey, ok := user.Password()
+		if !ok {
+			return nil, errAcqMsgMissingRequiredFieldPassword
+		}
+		key.accessKeyID, key.secretAccessKey = accessKeyID, secretAccessKey
+	} else {
+		key.role = cfg.role
+		key.profile = cfg.profile
+		key.sharedConfigFile = cfg.sharedConfigFile
+		key.sharedCredentialsFile = cfg.sharedCredentialsFile
+	}
+	return method.clients.get(key)
+}
+
+// endpointClientKey returns the key of the clients for the S3 endpoint at
+// s3URL with cfg, without credentials or a transport.
+func endpointClientKey(cfg *config, s3URL *url.URL) clientKey {
+	key := clientKey{
+		region:         cfg.region,
+		endpoint:       cfg.endpoint,
+		forcePathStyle: cfg.forcePathStyle,
+		disableSSL:     cfg.disableSSL,
+	}
+	if cfg.endpoint == "" {
+		key.endpointOptions = cfg.endpointOptions
+		if cfg.endpointOptions.fips {
+			// The SDK doesn't resolve FIPS endpoints for S3, so the endpoint
+			// is set explicitly.
+			key.endpoint = s3URL.String()
+		}
+	}
+	return key
+}
+
+// newS3Client builds a new S3 client for the given key.
+func newS3Client(key clientKey) (s3iface.S3API, error) {
+	config := &aws.Config{
+		Region:              aws.String(key.region),
+		STSRegionalEndpoint: key.role.stsRegionalEndpoint,
+	}
+	if key.transport != nil {
+		config.HTTPClient = &http.Client{Transport: key.transport}
+	}
+	opts := sessionOptions(key, config)
+	if key.accessKeyID == "" {
+		if err := checkSSOProfile(opts); err != nil {
+			return nil, err
+		}
+	}
+	sess, err := session.NewSessionWithOptions(opts)
+	if err != nil {
+		return nil, fmt.Errorf("creating AWS session: %w", err)
+	}
+
+	// The session is also used to assume roles, so settings of the S3
+	// endpoint are only applied to the S3 client.
+	config.S3ForcePathStyle = aws.Bool(key.forcePathStyle)
+	config.DisableSSL = aws.Bool(key.disableSSL)
+	config.UseDualStack = aws.Bool(key.endpointOptions.dualStack)
+	config.S3UseAccelerate = aws.Bool(key.endpointOptions.accelerate)
+	if key.endpoint != "" {
+		config.Endpoint = aws.String(key.endpoint)
+	}
+	if key.accessKeyID != "" {
+		config.Credentials = credentials.NewStaticCredentials(key.accessKeyID, key.secretAccessKey, "")
+	} else if key.role.arn != "" {
+		// Use default credential chain to assume specified role
+		config.Credentials = assumeRoleCredentials(sess, key.role)
+	} else if key.role.webIdentityTokenFile != "" {
+		// Falling back to the default credential chain would quietly use
+		// whatever other credentials the host has.
+		return nil, fmt.Errorf("%s: %w", configItemAcquireS3WebIdentity, errWebIdentityWithoutRole)
+	}
+
+	return s3.New(sess, config), nil
+}
+
+// assumeRoleCredentials returns credentials for the given role, using the
+// session's credentials to assume it. If the role has a source role, that is
+// assumed first and its credentials are used to assume the role.
+//
+// With a web identity token file the first role is assumed with
+// AssumeRoleWithWebIdentity instead, so no credentials need to be found in the
+// environment, which APT may have cleared. The external ID and duration don't
+// apply to that call.
+//
+// The returned credentials are only refreshed once they expire, and live as
+// long as the client they are used by.
+func assumeRoleCredentials(sess *session.Session, role roleConfig) *credentials.Credentials {
+	if role.sourceARN != "" {
+		source := assumeRoleCredentials(sess, roleConfig{
+			arn:                  role.sourceARN,
+			sessionName:          role.sessionName,
+			duration:             role.duration,
+			webIdentityTokenFile: role.webIdentityTokenFile,
+		})
+		sess = sess.Copy(&aws.Config{Credentials: source})
+	} else if role.webIdentityTokenFile != "" {
+		return stscreds.NewWebIdentityCredentials(sess, role.arn, role.sessionName, role.webIdentityTokenFile)
+	}
+	return stscreds.NewCredentials(sess, role.arn, func(p *stscreds.AssumeRoleProvider) {
+		if role.externalID != "" {
+			p.ExternalID = aws.String(role.externalID)
+		}
+		if role.sessionName != "" {
+			p.RoleSessionName = role.sessionName
+		}
+		if role.duration != 0 {
+			p.Duration = role.duration
+		}
+	})
+}
+
+// sessionOptions returns the options for the session of the given key. Named
+// profiles and custom shared config files enable the shared config, so that
+// profiles using role_arn and source_profile or credential_process resolve
+// their credentials.
+//
+// Profiles using SSO cached tokens are not supported, see checkSSOProfile.
+func sessionOptions(key clientKey, config *aws.Config) session.Options {
+	opts := session.Options{
+		Config:  *config,
+		Profile: key.profile,
+	}
+	if key.sharedConfigFile != "" || key.sharedCredentialsFile != "" {
+		// Setting SharedConfigFiles replaces the default files, so whichever
+		// one is not configured still needs to be listed. Later files take
+		// precedence, matching the SDK's default order.
+		credsFile, configFile := defaults.SharedCredentialsFilename(), defaults.SharedConfigFilename()
+		if key.sharedCredentialsFile != "" {
+			credsFile = key.sharedCredentialsFile
+		}
+		if key.sharedConfigFile != "" {
+			configFile = key.sharedConfigFile
+		}
+		opts.SharedConfigFiles = []string{credsFile, configFile}
+		opts.SharedConfigState = session.SharedConfigEnable
+	}
+	if key.profile != "" {
+		opts.SharedConfigState = session.SharedConfigEnable
+	}
+	return opts
+}
@@ -0,0 +1,214 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package method
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// stsResponse is the response of a fake STS endpoint to the action it is
+// formatted with.
+const stsResponse = `<%[1]sResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <%[1]sResult>
+    <Credentials>
+      <AccessKeyId>assumed-access-key-id</AccessKeyId>
+      <SecretAccessKey>assumed-access-key-secret</SecretAccessKey>
+      <SessionToken>assumed-session-token</SessionToken>
+      <Expiration>2099-01-01T00:00:00Z</Expiration>
+    </Credentials>
+  </%[1]sResult>
+</%[1]sResponse>`
+
+type fakeS3Client struct {
+	s3iface.S3API
+	key clientKey
+}
+
+func TestClientCache(t *testing.T) {
+	var mu sync.Mutex
+	builds := map[clientKey]int{}
+	method := New(logger(t))
+	method.clients = newClientCache(func(key clientKey) (s3iface.S3API, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		builds[key]++
+		return &fakeS3Client{key: key}, nil
+	})
+
+	cfg := &config{region: "us-east-2", role: roleConfig{arn: "arn:aws:iam::123456789012:role/apt"}}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := method.s3Client(cfg, nil); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	client, err := method.s3Client(cfg, url.UserPassword("fake-access-key-id", "fake-access-key-secret"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fake, ok := client.(*fakeS3Client)
+	if !ok {
+		t.Fatalf("method.s3Client() = %T; expected *fakeS3Client", client)
+	}
+	if fake.key.role.arn != "" {
+		t.Errorf("key.role.arn = %s; expected static credentials to take precedence over the role", fake.key.role.arn)
+	}
+
+	if len(builds) != 2 {
+		t.Errorf("Found %d distinct clients; expected %d", len(builds), 2)
+	}
+	for key, count := range builds {
+		if count != 1 {
+			t.Errorf("Client for %+v built %d times; expected once", key, count)
+		}
+	}
+}
+
+func TestClientMissingPassword(t *testing.T) {
+	method := New(logger(t))
+	_, err := method.s3Client(defaultConfig(), url.User("fake-access-key-id"))
+	if !errors.Is(err, errAcqMsgMissingRequiredFieldPassword) {
+		t.Errorf("method.s3Client() = %v; expected %v", err, errAcqMsgMissingRequiredFieldPassword)
+	}
+}
+
+func TestClientWebIdentityWithoutRole(t *testing.T) {
+	key := clientKey{region: "us-east-1", role: roleConfig{webIdentityTokenFile: "/var/run/secrets/tokens/apt"}}
+	if _, err := newS3Client(key); !errors.Is(err, errWebIdentityWithoutRole) {
+		t.Errorf("newS3Client() = %v; expected %v", err, errWebIdentityWithoutRole)
+	}
+}
+
+func TestAssumeRoleCredentials(t *testing.T) {
+	token, cleanup := tempFile(t)
+	defer cleanup()
+	if _, err := token.WriteString("fake-oidc-token"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	specs := map[string]struct {
+		role     roleConfig
+		expected []string
+	}{
+		"role": {
+			roleConfig{arn: "arn:aws:iam::123456789012:role/apt"},
+			[]string{"AssumeRole arn:aws:iam::123456789012:role/apt"},
+		},
+		"web identity": {
+			roleConfig{arn: "arn:aws:iam::123456789012:role/apt", webIdentityTokenFile: token.Name()},
+			[]string{"AssumeRoleWithWebIdentity arn:aws:iam::123456789012:role/apt"},
+		},
+		"web identity with source role": {
+			roleConfig{
+				arn:                  "arn:aws:iam::123456789012:role/apt",
+				sourceARN:            "arn:aws:iam::210987654321:role/source",
+				webIdentityTokenFile: token.Name(),
+			},
+			[]string{
+				"AssumeRoleWithWebIdentity arn:aws:iam::210987654321:role/source",
+				"AssumeRole arn:aws:iam::123456789012:role/apt",
+			},
+		},
+	}
+
+	for name, spec := range specs {
+		t.Run(name, func(t *testing.T) {
+			var calls []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				action := r.FormValue("Action")
+				calls = append(calls, action+" "+r.FormValue("RoleArn"))
+				fmt.Fprintf(w, stsResponse, action)
+			}))
+			defer server.Close()
+
+			sess, err := session.NewSession(&aws.Config{
+				Region:      aws.String("us-east-1"),
+				Endpoint:    aws.String(server.URL),
+				Credentials: credentials.NewStaticCredentials("fake-access-key-id", "fake-access-key-secret", ""),
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			creds, err := assumeRoleCredentials(sess, spec.role).Get()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if creds.AccessKeyID != "assumed-access-key-id" {
+				t.Errorf("creds.AccessKeyID = %s; expected assumed-access-key-id", creds.AccessKeyID)
+			}
+			if diff := cmp.Diff(spec.expected, calls); diff != "" {
+				t.Errorf("STS calls mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSessionOptions(t *testing.T) {
+	specs := map[string]struct {
+		key                 clientKey
+		expectedState       session.SharedConfigState
+		expectedConfigFiles []string
+	}{
+		"default chain": {
+			clientKey{region: "us-east-1"},
+			session.SharedConfigStateFromEnv,
+			nil,
+		},
+		"named profile": {
+			clientKey{region: "us-east-1", profile: "apt"},
+			session.SharedConfigEnable,
+			nil,
+		},
+		"custom config file": {
+			clientKey{region: "us-east-1", profile: "apt", sharedConfigFile: "/root/.aws/config"},
+			session.SharedConfigEnable,
+			[]string{defaults.SharedCredentialsFilename(), "/root/.aws/config"},
+		},
+	}
+
+	for name, spec := range specs {
+		t.Run(name, func(t *testing.T) {
+			opts := sessionOptions(spec.key, &aws.Config{})
+			if opts.Profile != spec.key.profile {
+				t.Errorf("opts.Profile = %s; expected %s", opts.Profile, spec.key.profile)
+			}
+			if opts.SharedConfigState != spec.expectedState {
+				t.Errorf("opts.SharedConfigState = %v; expected %v", opts.SharedConfigState, spec.expectedState)
+			}
+			if diff := cmp.Diff(spec.expectedConfigFiles, opts.SharedConfigFiles); diff != "" {
+				t.Errorf("opts.SharedConfigFiles mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
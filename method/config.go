@@ -0,0 +1,346 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package method
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+
+	"github.com/google/apt-golang-s3/aptconfig"
+)
+
+// Items that can be scoped to a bucket with Acquire::s3::Bucket::<bucket>::<item>.
+const (
+	bucketItemRegion   = "region"
+	bucketItemRole     = "role"
+	bucketItemEndpoint = "endpoint"
+	bucketItemProfile  = "profile"
+)
+
+const (
+	configItemAcquireS3Region          = "Acquire::s3::region"
+	configItemAcquireS3Role            = "Acquire::s3::role"
+	configItemAcquireS3RoleExternalID  = "Acquire::s3::role::external-id"
+	configItemAcquireS3RoleSessionName = "Acquire::s3::role::session-name"
+	configItemAcquireS3RoleDuration    = "Acquire::s3::role::duration"
+	configItemAcquireS3RoleSource      = "Acquire::s3::role::source-role"
+	configItemAcquireS3RoleSTSEndpoint = "Acquire::s3::role::sts-regional-endpoint"
+	configItemAcquireS3WebIdentity     = "Acquire::s3::web-identity-token-file"
+	configItemAcquireS3MaxParallel     = "Acquire::s3::Max-Parallel"
+	configItemAcquireS3PartConcurrency = "Acquire::s3::Part-Concurrency"
+	configItemAcquireS3Profile         = "Acquire::s3::profile"
+	configItemAcquireS3SharedConfig    = "Acquire::s3::shared-config-file"
+	configItemAcquireS3SharedCreds     = "Acquire::s3::shared-credentials-file"
+	configItemAcquireS3Endpoint        = "Acquire::s3::endpoint"
+	configItemAcquireS3ForcePathStyle  = "Acquire::s3::force-path-style"
+	configItemAcquireS3DisableSSL      = "Acquire::s3::disable-ssl"
+	configItemAcquireS3UseFIPS         = "Acquire::s3::use-fips"
+	configItemAcquireS3UseDualStack    = "Acquire::s3::use-dualstack"
+	configItemAcquireS3UseAccelerate   = "Acquire::s3::use-accelerate"
+	configItemAcquireS3Bucket          = "Acquire::s3::Bucket"
+	configItemAcquire                  = "Acquire"
+	configItemAcquireS3                = "Acquire::s3"
+	configItemCaInfo                   = "CaInfo"
+	configItemSslCert                  = "SslCert"
+	configItemSslKey                   = "SslKey"
+	configItemProxy                    = "Proxy"
+	configItemDir                      = "Dir"
+	configItemDirEtc                   = "Dir::Etc"
+	configItemDirEtcNetrc              = "Dir::Etc::netrc"
+	configItemDirEtcNetrcParts         = "Dir::Etc::netrcparts"
+)
+
+// defaultMaxParallel is the number of URIs acquired at the same time unless
+// Acquire::s3::Max-Parallel says otherwise.
+const defaultMaxParallel = 4
+
+// DefaultConfigTimeout is how long a Method waits for the Configuration
+// message from APT before falling back to its default configuration.
+const DefaultConfigTimeout = 10 * time.Second
+
+// A config is an immutable snapshot of the settings APT sends in its
+// Configuration message.
+type config struct {
+	region string
+	role   roleConfig
+	// endpoint, if set, is the URL of the S3 endpoint used instead of the
+	// one of the region, e.g. that of a MinIO server.
+	endpoint string
+	// forcePathStyle puts the bucket in the path of requests instead of the
+	// hostname, which most S3 compatible servers require.
+	forcePathStyle bool
+	// disableSSL uses plain HTTP for endpoints given without a scheme.
+	disableSSL bool
+	// endpointOptions select alternative AWS endpoints. They don't apply to
+	// custom endpoints.
+	endpointOptions endpointOptions
+	// profile names a profile in the shared AWS config files, which are read
+	// from sharedConfigFile and sharedCredentialsFile if set.
+	profile, sharedConfigFile, sharedCredentialsFile string
+	// maxParallel is the number of URIs acquired at the same time.
+	maxParallel int
+	// partConcurrency is the number of parts of a single object downloaded
+	// at the same time.
+	partConcurrency int
+	// dir, dirEtc, netrc and netrcParts locate APT's auth.conf files.
+	dir, dirEtc, netrc, netrcParts string
+	// hostEndpoints maps the hosts of URIs, in lower case, to the endpoints
+	// used for them.
+	hostEndpoints map[string]string
+	// buckets holds the settings scoped to individual buckets.
+	buckets map[string]bucketConfig
+	// tree is the configuration the config was built from, which holds the
+	// proxy and TLS settings that are looked up for each endpoint host.
+	tree *aptconfig.Config
+	// proxies holds the proxy settings for the http, https and s3 schemes.
+	proxies proxySettings
+	// transports is shared by all S3 clients built from the config and its
+	// scoped copies, so they use the same connection pools.
+	transports *transportCache
+}
+
+// A bucketConfig holds the settings scoped to a single bucket, e.g.
+//
+// Acquire::s3::Bucket::my-bucket::region "eu-west-1";
+//
+// Settings that are not set fall back to the global ones. A bucket's role
+// does not inherit the external ID and source role of the global role, which
+// belong to that role and often to a different account.
+type bucketConfig struct {
+	region, roleARN, endpoint, profile string
+}
+
+// A roleConfig describes the IAM role assumed to access S3.
+type roleConfig struct {
+	// arn is the role to assume. No role is assumed if it is empty.
+	arn string
+	// sourceARN, if set, is assumed first and its credentials are used to
+	// assume arn.
+	sourceARN   string
+	externalID  string
+	sessionName string
+	duration    time.Duration
+	// stsRegionalEndpoint selects between the global and regional STS
+	// endpoints.
+	stsRegionalEndpoint endpoints.STSRegionalEndpoint
+	// webIdentityTokenFile, if set, is the path of an OIDC token that is
+	// exchanged for the credentials of the first role assumed, instead of
+	// using the default credential chain.
+	webIdentityTokenFile string
+}
+
+// defaultConfig returns the config used when APT does not send any
+// configuration.
+func defaultConfig() *config {
+	return newConfig(aptconfig.New())
+}
+
+// newConfig builds a config from the configuration tree of a Configuration
+// message. Items that are not set, or set to invalid values, keep their
+// default value.
+func newConfig(tree *aptconfig.Config) *config {
+	cfg := &config{
+		region:          tree.String(configItemAcquireS3Region, endpoints.UsEast1RegionID),
+		endpoint:        tree.String(configItemAcquireS3Endpoint, ""),
+		forcePathStyle:  tree.Bool(configItemAcquireS3ForcePathStyle, false),
+		disableSSL:      tree.Bool(configItemAcquireS3DisableSSL, false),
+		profile:         tree.String(configItemAcquireS3Profile, ""),
+		maxParallel:     defaultMaxParallel,
+		partConcurrency: s3manager.DefaultDownloadConcurrency,
+		dir:             tree.String(configItemDir, "/"),
+		dirEtc:          tree.String(configItemDirEtc, "etc/apt/"),
+		netrc:           tree.String(configItemDirEtcNetrc, "auth.conf"),
+		netrcParts:      tree.String(configItemDirEtcNetrcParts, "auth.conf.d"),
+		hostEndpoints:   hostEndpoints(tree),
+		buckets:         bucketConfigs(tree),
+		tree:            tree,
+		proxies:         proxySettings{tree: tree},
+	}
+	cfg.role = newRoleConfig(tree)
+	cfg.endpointOptions = endpointOptions{
+		fips:       tree.Bool(configItemAcquireS3UseFIPS, false),
+		dualStack:  tree.Bool(configItemAcquireS3UseDualStack, false),
+		accelerate: tree.Bool(configItemAcquireS3UseAccelerate, false),
+	}
+	cfg.sharedConfigFile = tree.String(configItemAcquireS3SharedConfig, "")
+	cfg.sharedCredentialsFile = tree.String(configItemAcquireS3SharedCreds, "")
+	if n := tree.Int(configItemAcquireS3MaxParallel, 0); n > 0 {
+		cfg.maxParallel = n
+	}
+	if n := tree.Int(configItemAcquireS3PartConcurrency, 0); n > 0 {
+		cfg.partConcurrency = n
+	}
+
+	cfg.transports = newTransportCache(cfg.proxies)
+	return cfg
+}
+
+// newRoleConfig reads the role to assume from the configuration tree.
+func newRoleConfig(tree *aptconfig.Config) roleConfig {
+	role := roleConfig{
+		arn:                  tree.String(configItemAcquireS3Role, ""),
+		sourceARN:            tree.String(configItemAcquireS3RoleSource, ""),
+		externalID:           tree.String(configItemAcquireS3RoleExternalID, ""),
+		sessionName:          tree.String(configItemAcquireS3RoleSessionName, ""),
+		webIdentityTokenFile: tree.String(configItemAcquireS3WebIdentity, ""),
+	}
+	if d := tree.Duration(configItemAcquireS3RoleDuration, 0); d > 0 {
+		role.duration = d
+	}
+	if value, ok := tree.Find(configItemAcquireS3RoleSTSEndpoint); ok {
+		if stsEndpoint, err := endpoints.GetSTSRegionalEndpoint(value); err == nil {
+			role.stsRegionalEndpoint = stsEndpoint
+		}
+	}
+	return role
+}
+
+// hostEndpoints reads the endpoints configured for the hosts of URIs, e.g.
+//
+// Acquire::s3::endpoint::<host> "<endpoint>";
+func hostEndpoints(tree *aptconfig.Config) map[string]string {
+	byHost := map[string]string{}
+	for _, host := range tree.Tags(configItemAcquireS3Endpoint) {
+		if endpoint, ok := tree.Find(configName(configItemAcquireS3Endpoint, host)); ok {
+			byHost[strings.ToLower(host)] = endpoint
+		}
+	}
+	return byHost
+}
+
+// bucketConfigs reads the settings scoped to buckets, e.g.
+//
+// Acquire::s3::Bucket::<bucket>::<item> "<value>";
+func bucketConfigs(tree *aptconfig.Config) map[string]bucketConfig {
+	buckets := map[string]bucketConfig{}
+	for _, bucket := range tree.Tags(configItemAcquireS3Bucket) {
+		scope := tree.Sub(configName(configItemAcquireS3Bucket, bucket))
+		settings := bucketConfig{
+			region:   scope.String(bucketItemRegion, ""),
+			roleARN:  scope.String(bucketItemRole, ""),
+			endpoint: scope.String(bucketItemEndpoint, ""),
+			profile:  scope.String(bucketItemProfile, ""),
+		}
+		if settings != (bucketConfig{}) {
+			buckets[bucket] = settings
+		}
+	}
+	return buckets
+}
+
+// configName joins the given tags into the name of a config item.
+func configName(tags ...string) string {
+	return strings.Join(tags, "::")
+}
+
+// tlsFor returns the TLS settings for connections to the given endpoint host,
+// which may be set for all endpoints or for the host, like APT's
+// Acquire::https::<host>::CaInfo. Settings for the host take precedence over
+// the global ones. The client certificate and key are used as a pair: a key
+// for the host is only used together with a certificate for the host.
+func (cfg *config) tlsFor(host string) tlsSettings {
+	hostItem := func(item string) string {
+		return configName(configItemAcquireS3, host, item)
+	}
+	global := func(item string) string {
+		return configName(configItemAcquireS3, item)
+	}
+	settings := tlsSettings{}
+	settings.caInfo, _ = cfg.tree.Lookup(hostItem(configItemCaInfo), global(configItemCaInfo))
+	if sslCert, ok := cfg.tree.Find(hostItem(configItemSslCert)); ok && sslCert != "" {
+		settings.sslCert = sslCert
+		settings.sslKey, _ = cfg.tree.Find(hostItem(configItemSslKey))
+	} else {
+		settings.sslCert, _ = cfg.tree.Find(global(configItemSslCert))
+		settings.sslKey, _ = cfg.tree.Find(global(configItemSslKey))
+	}
+	return settings
+}
+
+// forHost returns the config to use for URIs with the given host, i.e. cfg
+// with the endpoint configured for the host, e.g.
+//
+// Acquire::s3::endpoint::minio.internal:9000 "http://minio.internal:9000";
+func (cfg *config) forHost(host string) *config {
+	endpoint, ok := cfg.hostEndpoints[strings.ToLower(host)]
+	if !ok {
+		return cfg
+	}
+	scoped := *cfg
+	scoped.endpoint = endpoint
+	return &scoped
+}
+
+// forBucket returns the config to use for the given bucket, i.e. cfg with the
+// settings scoped to the bucket applied. It reports whether there were any.
+func (cfg *config) forBucket(bucket string) (*config, bool) {
+	scope, ok := cfg.buckets[bucket]
+	if !ok {
+		return cfg, false
+	}
+	scoped := *cfg
+	if scope.region != "" {
+		scoped.region = scope.region
+	}
+	if scope.roleARN != "" {
+		// The external ID and source role belong to the global role, while
+		// the web identity token, session name and duration apply to any.
+		scoped.role.arn = scope.roleARN
+		scoped.role.externalID = ""
+		scoped.role.sourceARN = ""
+	}
+	if scope.endpoint != "" {
+		scoped.endpoint = scope.endpoint
+	}
+	if scope.profile != "" {
+		scoped.profile = scope.profile
+	}
+	return &scoped, true
+}
+
+// publishConfig makes cfg the Method's configuration and releases everything
+// waiting for it. Only the first call has any effect; it reports whether cfg
+// was published.
+func (method *Method) publishConfig(cfg *config) bool {
+	published := false
+	method.configOnce.Do(func() {
+		method.config = cfg
+		close(method.configured)
+		published = true
+	})
+	return published
+}
+
+// waitForConfiguration blocks until the configuration Message from APT has
+// been fully processed and returns the resulting config. If none arrives
+// within the Method's configuration timeout, the default configuration is
+// published and returned instead.
+func (method *Method) waitForConfiguration() *config {
+	timer := time.NewTimer(method.configTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-method.configured:
+	case <-timer.C:
+		if method.publishConfig(defaultConfig()) {
+			method.outputGeneralLog(fmt.Sprintf("No configuration received within %s, using defaults", method.configTimeout))
+		}
+	}
+	<-method.configured
+	return method.config
+}
@@ -0,0 +1,242 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package method
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+
+	"github.com/google/apt-golang-s3/aptconfig"
+)
+
+func TestNewConfigParallelism(t *testing.T) {
+	specs := map[string]struct {
+		items                   []string
+		expectedMaxParallel     int
+		expectedPartConcurrency int
+	}{
+		"defaults": {
+			nil,
+			4,
+			5,
+		},
+		"configured": {
+			[]string{"Acquire::s3::Max-Parallel=2", "Acquire::s3::Part-Concurrency=1"},
+			2,
+			1,
+		},
+		"invalid values": {
+			[]string{"Acquire::s3::Max-Parallel=0", "Acquire::s3::Part-Concurrency=many"},
+			4,
+			5,
+		},
+	}
+
+	for name, spec := range specs {
+		t.Run(name, func(t *testing.T) {
+			cfg := newConfig(configTree(t, spec.items...))
+			if cfg.maxParallel != spec.expectedMaxParallel {
+				t.Errorf("cfg.maxParallel = %d; expected %d", cfg.maxParallel, spec.expectedMaxParallel)
+			}
+			if cfg.partConcurrency != spec.expectedPartConcurrency {
+				t.Errorf("cfg.partConcurrency = %d; expected %d", cfg.partConcurrency, spec.expectedPartConcurrency)
+			}
+		})
+	}
+}
+
+func TestNewConfigRole(t *testing.T) {
+	cfg := newConfig(configTree(t,
+		"Acquire::s3::role=arn:aws:iam::123456789012:role/apt",
+		"Acquire::s3::role::external-id=apt-external-id",
+		"Acquire::s3::role::session-name=apt-golang-s3",
+		"Acquire::s3::role::duration=900",
+		"Acquire::s3::role::source-role=arn:aws:iam::210987654321:role/source",
+		"Acquire::s3::role::sts-regional-endpoint=regional",
+		"Acquire::s3::web-identity-token-file=/var/run/secrets/tokens/apt",
+	))
+
+	expected := roleConfig{
+		arn:                  "arn:aws:iam::123456789012:role/apt",
+		sourceARN:            "arn:aws:iam::210987654321:role/source",
+		externalID:           "apt-external-id",
+		sessionName:          "apt-golang-s3",
+		duration:             15 * time.Minute,
+		stsRegionalEndpoint:  endpoints.RegionalSTSEndpoint,
+		webIdentityTokenFile: "/var/run/secrets/tokens/apt",
+	}
+	if diff := cmp.Diff(expected, cfg.role, cmp.AllowUnexported(roleConfig{})); diff != "" {
+		t.Errorf("cfg.role mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestConfigForBucket(t *testing.T) {
+	cfg := newConfig(configTree(t,
+		"Acquire::s3::region=us-east-1",
+		"Acquire::s3::role=arn:aws:iam::123456789012:role/apt",
+		"Acquire::s3::role::external-id=apt-external-id",
+		"Acquire::s3::role::source-role=arn:aws:iam::123456789012:role/source",
+		"Acquire::s3::role::session-name=apt",
+		"Acquire::s3::role::duration=900",
+		"Acquire::s3::web-identity-token-file=/var/run/secrets/tokens/apt",
+		"Acquire::s3::Bucket::eu-bucket::region=eu-west-1",
+		"Acquire::s3::Bucket::eu-bucket::role=arn:aws:iam::210987654321:role/apt",
+		"Acquire::s3::Bucket::minio-bucket::endpoint=https://minio.internal:9000",
+		"Acquire::s3::Bucket::minio-bucket::profile=minio",
+		"Acquire::s3::Bucket::minio-bucket::unknown=ignored",
+	))
+	globalRole := roleConfig{
+		arn:                  "arn:aws:iam::123456789012:role/apt",
+		sourceARN:            "arn:aws:iam::123456789012:role/source",
+		externalID:           "apt-external-id",
+		sessionName:          "apt",
+		duration:             15 * time.Minute,
+		webIdentityTokenFile: "/var/run/secrets/tokens/apt",
+	}
+
+	specs := map[string]struct {
+		expectedScoped   bool
+		expectedRegion   string
+		expectedRole     roleConfig
+		expectedEndpoint string
+		expectedProfile  string
+	}{
+		"eu-bucket": {
+			true,
+			"eu-west-1",
+			roleConfig{
+				arn:                  "arn:aws:iam::210987654321:role/apt",
+				sessionName:          "apt",
+				duration:             15 * time.Minute,
+				webIdentityTokenFile: "/var/run/secrets/tokens/apt",
+			},
+			"",
+			"",
+		},
+		"minio-bucket": {
+			true,
+			"us-east-1",
+			globalRole,
+			"https://minio.internal:9000",
+			"minio",
+		},
+		"other-bucket": {
+			false,
+			"us-east-1",
+			globalRole,
+			"",
+			"",
+		},
+	}
+
+	for bucket, spec := range specs {
+		t.Run(bucket, func(t *testing.T) {
+			bucketCfg, scoped := cfg.forBucket(bucket)
+			if scoped != spec.expectedScoped {
+				t.Errorf("forBucket(%s) scoped = %t; expected %t", bucket, scoped, spec.expectedScoped)
+			}
+			if bucketCfg.region != spec.expectedRegion {
+				t.Errorf("region = %s; expected %s", bucketCfg.region, spec.expectedRegion)
+			}
+			if diff := cmp.Diff(spec.expectedRole, bucketCfg.role, cmp.AllowUnexported(roleConfig{})); diff != "" {
+				t.Errorf("role mismatch (-want +got):\n%s", diff)
+			}
+			if bucketCfg.endpoint != spec.expectedEndpoint {
+				t.Errorf("endpoint = %s; expected %s", bucketCfg.endpoint, spec.expectedEndpoint)
+			}
+			if bucketCfg.profile != spec.expectedProfile {
+				t.Errorf("profile = %s; expected %s", bucketCfg.profile, spec.expectedProfile)
+			}
+		})
+	}
+
+	if cfg.region != "us-east-1" {
+		t.Errorf("cfg.region = %s; expected the global config to be unchanged", cfg.region)
+	}
+}
+
+func TestNewConfigEndpoint(t *testing.T) {
+	cfg := newConfig(configTree(t,
+		"Acquire::s3::endpoint=https://s3.internal",
+		"Acquire::s3::endpoint::minio.internal:9000=http://minio.internal:9000",
+		"Acquire::s3::force-path-style=true",
+		"Acquire::s3::disable-ssl=yes",
+		"Acquire::s3::use-fips=true",
+		"Acquire::s3::use-dualstack=on",
+		"Acquire::s3::use-accelerate=false",
+	))
+
+	if cfg.endpoint != "https://s3.internal" {
+		t.Errorf("cfg.endpoint = %s; expected https://s3.internal", cfg.endpoint)
+	}
+	if !cfg.forcePathStyle {
+		t.Error("cfg.forcePathStyle = false; expected true")
+	}
+	if !cfg.disableSSL {
+		t.Error("cfg.disableSSL = false; expected true")
+	}
+	if expected := (endpointOptions{fips: true, dualStack: true}); cfg.endpointOptions != expected {
+		t.Errorf("cfg.endpointOptions = %+v; expected %+v", cfg.endpointOptions, expected)
+	}
+	if actual := cfg.forHost("minio.internal:9000").endpoint; actual != "http://minio.internal:9000" {
+		t.Errorf("forHost(minio.internal:9000).endpoint = %s; expected http://minio.internal:9000", actual)
+	}
+	if actual := cfg.forHost("minio.internal").endpoint; actual != "https://s3.internal" {
+		t.Errorf("forHost(minio.internal).endpoint = %s; expected the global endpoint", actual)
+	}
+}
+
+func TestNewConfigInvalidValues(t *testing.T) {
+	cfg := newConfig(configTree(t,
+		"Acquire::s3::force-path-style=maybe",
+		"Acquire::s3::role::duration=0",
+		"Acquire::s3::role::sts-regional-endpoint=nearby",
+	))
+	if cfg.forcePathStyle {
+		t.Errorf("cfg.forcePathStyle = %t; expected %t", cfg.forcePathStyle, false)
+	}
+	if cfg.role.duration != 0 {
+		t.Errorf("cfg.role.duration = %s; expected %s", cfg.role.duration, time.Duration(0))
+	}
+	if cfg.role.stsRegionalEndpoint != endpoints.UnsetSTSEndpoint {
+		t.Errorf("cfg.role.stsRegionalEndpoint = %v; expected %v", cfg.role.stsRegionalEndpoint, endpoints.UnsetSTSEndpoint)
+	}
+}
+
+func TestNewConfigCaseInsensitive(t *testing.T) {
+	cfg := newConfig(configTree(t,
+		"acquire::S3::Region=eu-west-1",
+		"ACQUIRE::s3::endpoint::MinIO.internal:9000=http%3A//minio.internal:9000",
+	))
+	if cfg.region != "eu-west-1" {
+		t.Errorf("cfg.region = %s; expected %s", cfg.region, "eu-west-1")
+	}
+	if actual := cfg.forHost("minio.internal:9000").endpoint; actual != "http://minio.internal:9000" {
+		t.Errorf("cfg.forHost().endpoint = %s; expected %s", actual, "http://minio.internal:9000")
+	}
+}
+
+// configTree parses the given Config-Item values.
+func configTree(t *testing.T, items ...string) *aptconfig.Config {
+	t.Helper()
+	tree, err := aptconfig.Parse(items)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return tree
+}
@@ -0,0 +1,189 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package method
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/google/apt-golang-s3/message"
+)
+
+// digests holds the hex encoded hashes of a downloaded file that are reported
+// back to APT.
+type digests struct {
+	md5, sha1, sha256, sha512 string
+}
+
+// A hashSumMismatchError reports that a downloaded file does not match one of
+// the hashes APT expected it to have.
+type hashSumMismatchError struct {
+	hashType, expected, actual string
+}
+
+func (e *hashSumMismatchError) Error() string {
+	return fmt.Sprintf("%s hash sum mismatch: expected %s, got %s", e.hashType, e.expected, e.actual)
+}
+
+// verify compares the digests against the Expected-* hashes of the given URI
+// Acquire request. Hashes that APT did not send are not checked.
+func (sums digests) verify(req message.URIAcquire) error {
+	expectations := []struct {
+		expected, hashType, actual string
+	}{
+		{req.ExpectedSHA512, "SHA512", sums.sha512},
+		{req.ExpectedSHA256, "SHA256", sums.sha256},
+		{req.ExpectedSHA1, "SHA1", sums.sha1},
+		{req.ExpectedMD5Sum, "MD5Sum", sums.md5},
+	}
+	for _, e := range expectations {
+		if e.expected != "" && !strings.EqualFold(e.expected, e.actual) {
+			return &hashSumMismatchError{hashType: e.hashType, expected: e.expected, actual: e.actual}
+		}
+	}
+	return nil
+}
+
+// A hashingWriter is an io.WriterAt that writes to a file and computes the
+// digests of its contents in a single pass. Offsets passed to WriteAt are
+// relative to the point the download was resumed from, if any.
+//
+// s3manager.Downloader writes the parts of an object concurrently, so data may
+// arrive ahead of the contiguous prefix that has been hashed so far. That data
+// is only recorded as pending and is read back from the file once the gap in
+// front of it has been filled, which keeps memory use independent of the size
+// of the object.
+type hashingWriter struct {
+	mu   sync.Mutex
+	file *os.File
+	// base is the offset in the file that writes are relative to.
+	base int64
+
+	md5, sha1, sha256, sha512 hash.Hash
+	hashes                    io.Writer
+
+	// hashed is the length of the prefix of the file that has been hashed.
+	hashed int64
+	// pending maps the start file offset of written but not yet hashed ranges
+	// to their end file offset.
+	pending map[int64]int64
+}
+
+// newHashingWriter returns a hashingWriter that writes to file starting at the
+// given offset. The content of the file before that offset, e.g. the partial
+// file of an interrupted download, is hashed first.
+func newHashingWriter(file *os.File, offset int64) (*hashingWriter, error) {
+	w := &hashingWriter{
+		file:    file,
+		base:    offset,
+		md5:     md5.New(),
+		sha1:    sha1.New(),
+		sha256:  sha256.New(),
+		sha512:  sha512.New(),
+		pending: map[int64]int64{},
+	}
+	w.hashes = io.MultiWriter(w.md5, w.sha1, w.sha256, w.sha512)
+	if err := w.hashRange(offset); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// WriteAt writes p to the underlying file at offset off past the resume
+// point, hashing it straight away if it extends the hashed prefix of the file.
+func (w *hashingWriter) WriteAt(p []byte, off int64) (int, error) {
+	off += w.base
+	n, err := w.file.WriteAt(p, off)
+	if err != nil {
+		return n, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	end := off + int64(n)
+	switch {
+	case end <= w.hashed:
+		// The range was already hashed, e.g. by an earlier attempt of a
+		// retried part. The bytes are identical, so there's nothing to do.
+	case off <= w.hashed:
+		// hash.Hash writes never return an error.
+		_, _ = w.hashes.Write(p[w.hashed-off : n])
+		w.hashed = end
+		return n, w.catchUp()
+	default:
+		if end > w.pending[off] {
+			w.pending[off] = end
+		}
+	}
+	return n, nil
+}
+
+// catchUp hashes pending ranges that directly follow the hashed prefix.
+func (w *hashingWriter) catchUp() error {
+	for {
+		end, ok := w.pending[w.hashed]
+		if !ok {
+			return nil
+		}
+		delete(w.pending, w.hashed)
+		if err := w.hashRange(end); err != nil {
+			return err
+		}
+	}
+}
+
+// hashRange reads the file from the end of the hashed prefix up to end and
+// adds it to the hashes.
+func (w *hashingWriter) hashRange(end int64) error {
+	section := io.NewSectionReader(w.file, w.hashed, end-w.hashed)
+	n, err := io.Copy(w.hashes, section)
+	w.hashed += n
+	if err == nil && w.hashed < end {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return fmt.Errorf("hashing %s: %w", w.file.Name(), err)
+	}
+	return nil
+}
+
+// digests returns the digests of the first size bytes of the file, including
+// any content before the resume point. Any part of that range that could not be
+// hashed while it was written is read back from the file first.
+func (w *hashingWriter) digests(size int64) (digests, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.hashed < size {
+		if err := w.hashRange(size); err != nil {
+			return digests{}, err
+		}
+	}
+	return digests{
+		md5:    hex.EncodeToString(w.md5.Sum(nil)),
+		sha1:   hex.EncodeToString(w.sha1.Sum(nil)),
+		sha256: hex.EncodeToString(w.sha256.Sum(nil)),
+		sha512: hex.EncodeToString(w.sha512.Sum(nil)),
+	}, nil
+}
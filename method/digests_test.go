@@ -0,0 +1,165 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package method
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/google/apt-golang-s3/message"
+)
+
+func TestHashingWriter(t *testing.T) {
+	sums := writeDigests(t, []byte("hello"))
+	expected := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	if sums.sha256 != expected {
+		t.Errorf("sums.sha256 = %s; expected %s", sums.sha256, expected)
+	}
+}
+
+func TestHashingWriterOutOfOrder(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+	file, cleanup := tempFile(t)
+	defer cleanup()
+	sink, err := newHashingWriter(file, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Write the chunks back to front, with the middle chunk written twice the
+	// way a retried part would be.
+	chunks := []struct{ start, end int }{
+		{12000, len(content)},
+		{4000, 8000},
+		{8000, 12000},
+		{4000, 8000},
+		{0, 4000},
+	}
+	for _, c := range chunks {
+		if _, err := sink.WriteAt(content[c.start:c.end], int64(c.start)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	sums, err := sink.digests(int64(len(content)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sum := sha512.Sum512(content)
+	expected := hex.EncodeToString(sum[:])
+	if sums.sha512 != expected {
+		t.Errorf("sums.sha512 = %s; expected %s", sums.sha512, expected)
+	}
+	if len(sink.pending) != 0 {
+		t.Errorf("Found %d pending ranges; expected none", len(sink.pending))
+	}
+}
+
+func TestHashingWriterResumed(t *testing.T) {
+	file, cleanup := tempFile(t)
+	defer cleanup()
+	if _, err := file.WriteString("hel"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sink, err := newHashingWriter(file, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := sink.WriteAt([]byte("lo"), 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sums, err := sink.digests(5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	if sums.sha256 != expected {
+		t.Errorf("sums.sha256 = %s; expected %s", sums.sha256, expected)
+	}
+}
+
+func TestVerifyDigests(t *testing.T) {
+	sums := writeDigests(t, []byte("hello"))
+	specs := map[string]struct {
+		fields   string
+		expected string
+	}{
+		"no expected hashes": {"", ""},
+		"matching hashes": {
+			"Expected-SHA256: 2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824\nExpected-MD5Sum: 5d41402abc4b2a76b9719d911017c592\n",
+			"",
+		},
+		"mismatched hash": {
+			"Expected-SHA256: 0000000000000000000000000000000000000000000000000000000000000000\n",
+			"HashSumMismatch",
+		},
+	}
+
+	for name, spec := range specs {
+		t.Run(name, func(t *testing.T) {
+			msg, err := message.FromBytes([]byte("600 URI Acquire\nURI: s3://bucket/key\nFilename: /tmp/key\n" + spec.fields))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var req message.URIAcquire
+			if err := message.Unmarshal(msg, &req); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			err = sums.verify(req)
+			if spec.expected == "" && err != nil {
+				t.Errorf("sums.verify() = %v; expected no error", err)
+			}
+			if spec.expected != "" && failReason(err) != spec.expected {
+				t.Errorf("failReason(sums.verify()) = %q; expected %q", failReason(err), spec.expected)
+			}
+		})
+	}
+}
+
+func writeDigests(t *testing.T, content []byte) digests {
+	t.Helper()
+	file, cleanup := tempFile(t)
+	defer cleanup()
+	sink, err := newHashingWriter(file, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := sink.WriteAt(content, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sums, err := sink.digests(int64(len(content)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return sums
+}
+
+// tempFile creates a temporary file, along with a function that closes and
+// removes it.
+func tempFile(t *testing.T) (*os.File, func()) {
+	t.Helper()
+	file, err := ioutil.TempFile("", "download")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return file, func() {
+		file.Close()
+		os.Remove(file.Name())
+	}
+}
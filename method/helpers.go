@@ -16,18 +16,101 @@ package method
 import (
 	"fmt"
 	"net/url"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/service/s3"
 )
 
-func s3EndpointURL(region string) (*url.URL, error) {
+// endpointURL returns the URL of the S3 endpoint to use with cfg. Like the
+// SDK, a custom endpoint without a scheme uses HTTPS unless SSL is disabled.
+func (cfg *config) endpointURL() (*url.URL, error) {
+	if cfg.endpoint != "" {
+		endpoint := cfg.endpoint
+		if !strings.Contains(endpoint, "://") {
+			scheme := "https"
+			if cfg.disableSSL {
+				scheme = "http"
+			}
+			endpoint = scheme + "://" + endpoint
+		}
+		endpointURL, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("parsing S3 endpoint %s: %w", cfg.endpoint, err)
+		}
+		return endpointURL, nil
+	}
+	return s3EndpointURL(cfg.region, cfg.endpointOptions)
+}
+
+// s3Hosts returns the hosts of the S3 endpoints that URIs may refer to with
+// cfg. Besides the host of the endpoint in use, that is the host of the
+// region's plain endpoint when an alternative one is selected, so that
+// existing sources keep working.
+func (cfg *config) s3Hosts() ([]string, error) {
+	s3URL, err := cfg.endpointURL()
+	if err != nil {
+		return nil, err
+	}
+	hosts := []string{s3URL.Host}
+	if cfg.endpoint == "" && cfg.endpointOptions != (endpointOptions{}) {
+		plainURL, err := s3EndpointURL(cfg.region, endpointOptions{})
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, plainURL.Host)
+	}
+	return hosts, nil
+}
+
+// endpointOptions select alternative S3 endpoints of a region.
+type endpointOptions struct {
+	// fips uses the FIPS 140-2 validated endpoints.
+	fips bool
+	// dualStack uses the endpoints that are reachable over IPv6 as well as
+	// IPv4.
+	dualStack bool
+	// accelerate uses the S3 Transfer Acceleration endpoints.
+	accelerate bool
+}
+
+// s3EndpointURL returns the URL of the S3 endpoint of the region, e.g.
+//
+// https://s3.eu-west-1.amazonaws.com
+// https://s3.dualstack.eu-west-1.amazonaws.com
+// https://s3-fips.us-gov-west-1.amazonaws.com
+// https://s3-accelerate.amazonaws.com
+func s3EndpointURL(region string, opts endpointOptions) (*url.URL, error) {
 	resolver := endpoints.DefaultResolver()
 
-	endpoint, err := resolver.EndpointFor(s3.EndpointsID, region, endpoints.StrictMatchingOption)
+	resolveOpts := []func(*endpoints.Options){endpoints.StrictMatchingOption}
+	if opts != (endpointOptions{}) {
+		resolveOpts = append(resolveOpts, endpoints.UseDualStackOption)
+	}
+	endpoint, err := resolver.EndpointFor(s3.EndpointsID, region, resolveOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("resolving S3 endpoint for region %s: %w", region, err)
 	}
 
-	return url.Parse(endpoint.URL)
+	s3URL, err := url.Parse(endpoint.URL)
+	if err != nil || (!opts.fips && !opts.accelerate) {
+		return s3URL, err
+	}
+
+	// The SDK does not resolve FIPS or accelerate endpoints, but they share the
+	// DNS suffix of the region's dual-stack endpoint.
+	dnsSuffix := strings.TrimPrefix(s3URL.Host, "s3.dualstack."+region+".")
+	dualStack := ""
+	if opts.dualStack {
+		dualStack = ".dualstack"
+	}
+	switch {
+	case opts.fips && opts.accelerate:
+		return nil, errEndpointFIPSAccelerate
+	case opts.fips:
+		s3URL.Host = "s3-fips" + dualStack + "." + region + "." + dnsSuffix
+	default:
+		s3URL.Host = "s3-accelerate" + dualStack + "." + dnsSuffix
+	}
+	return s3URL, nil
 }
@@ -61,7 +61,7 @@ func TestS3EndpointURL(t *testing.T) {
 
 	for region, spec := range specs {
 		t.Run(region, func(t *testing.T) {
-			s3URL, err := s3EndpointURL(region)
+			s3URL, err := s3EndpointURL(region, endpointOptions{})
 			if err != nil && !spec.expectError {
 				t.Errorf("expected s3EndpointURL(%#v) not to return an error but got %#v", region, err)
 			} else if err == nil && spec.expectError {
@@ -74,3 +74,109 @@ func TestS3EndpointURL(t *testing.T) {
 		})
 	}
 }
+
+func TestConfigEndpointURL(t *testing.T) {
+	specs := map[string]struct {
+		cfg         *config
+		expectedURL *url.URL
+	}{
+		"region": {
+			&config{region: "eu-west-1"},
+			&url.URL{Scheme: "https", Host: "s3.eu-west-1.amazonaws.com"},
+		},
+		"custom endpoint": {
+			&config{region: "us-east-1", endpoint: "http://minio.internal:9000"},
+			&url.URL{Scheme: "http", Host: "minio.internal:9000"},
+		},
+		"custom endpoint without scheme": {
+			&config{region: "us-east-1", endpoint: "minio.internal:9000"},
+			&url.URL{Scheme: "https", Host: "minio.internal:9000"},
+		},
+		"custom endpoint without scheme and SSL disabled": {
+			&config{region: "us-east-1", endpoint: "minio.internal:9000", disableSSL: true},
+			&url.URL{Scheme: "http", Host: "minio.internal:9000"},
+		},
+	}
+
+	for name, spec := range specs {
+		t.Run(name, func(t *testing.T) {
+			s3URL, err := spec.cfg.endpointURL()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(spec.expectedURL, s3URL); diff != "" {
+				t.Errorf("endpointURL() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestS3EndpointURLOptions(t *testing.T) {
+	specs := map[string]struct {
+		region       string
+		opts         endpointOptions
+		expectedHost string
+		expectError  bool
+	}{
+		"dual-stack": {
+			"us-east-1",
+			endpointOptions{dualStack: true},
+			"s3.dualstack.us-east-1.amazonaws.com",
+			false,
+		},
+		"fips": {
+			"us-gov-west-1",
+			endpointOptions{fips: true},
+			"s3-fips.us-gov-west-1.amazonaws.com",
+			false,
+		},
+		"fips dual-stack": {
+			"us-east-2",
+			endpointOptions{fips: true, dualStack: true},
+			"s3-fips.dualstack.us-east-2.amazonaws.com",
+			false,
+		},
+		"accelerate": {
+			"eu-west-1",
+			endpointOptions{accelerate: true},
+			"s3-accelerate.amazonaws.com",
+			false,
+		},
+		"accelerate dual-stack": {
+			"us-east-1",
+			endpointOptions{accelerate: true, dualStack: true},
+			"s3-accelerate.dualstack.amazonaws.com",
+			false,
+		},
+		"dual-stack in china": {
+			"cn-north-1",
+			endpointOptions{dualStack: true},
+			"s3.dualstack.cn-north-1.amazonaws.com.cn",
+			false,
+		},
+		"fips accelerate": {
+			"us-east-1",
+			endpointOptions{fips: true, accelerate: true},
+			"",
+			true,
+		},
+	}
+
+	for name, spec := range specs {
+		t.Run(name, func(t *testing.T) {
+			s3URL, err := s3EndpointURL(spec.region, spec.opts)
+			if spec.expectError {
+				if err == nil {
+					t.Errorf("expected s3EndpointURL(%s, %+v) to return an error but got %s", spec.region, spec.opts, s3URL)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if s3URL.Host != spec.expectedHost {
+				t.Errorf("s3EndpointURL(%s, %+v).Host = %s; expected %s", spec.region, spec.opts, s3URL.Host, spec.expectedHost)
+			}
+		})
+	}
+}
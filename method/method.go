@@ -18,91 +18,46 @@
 package method
 
 import (
-	"bufio"
-	"bytes"
-	"crypto/md5"
-	"crypto/sha1"
-	"crypto/sha256"
-	"crypto/sha512"
-	"encoding/hex"
+	"context"
 	"errors"
 	"fmt"
-	"hash"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
-	"github.com/aws/aws-sdk-go/aws/endpoints"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 
+	"github.com/google/apt-golang-s3/aptconfig"
 	"github.com/google/apt-golang-s3/message"
 )
 
 const (
-	headerCodeCapabilities   = 100
-	headerCodeGeneralLog     = 101
-	headerCodeStatus         = 102
-	headerCodeURIStart       = 200
-	headerCodeURIDone        = 201
-	headerCodeURIFailure     = 400
-	headerCodeGeneralFailure = 401
-	headerCodeURIAcquire     = 600
-	headerCodeConfiguration  = 601
-)
-
-const (
-	headerDescriptionCapabilities   = "Capabilities"
-	headerDescriptionGeneralLog     = "Log"
-	headerDescriptionStatus         = "Status"
-	headerDescriptionURIStart       = "URI Start"
-	headerDescriptionURIDone        = "URI Done"
-	headerDescriptionURIFailure     = "URI Failure"
-	headerDescriptionGeneralFailure = "General Failure"
-	headerDescriptionURIAcquire     = "URI Acquire"
-	headerDescriptionConfiguration  = "Configuration"
-)
-
-const (
-	fieldNameCapabilities   = "Capabilities"
-	fieldNameConfigItem     = "Config-Item"
-	fieldNameSendConfig     = "Send-Config"
-	fieldNamePipeline       = "Pipeline"
-	fieldNameSingleInstance = "Single-Instance"
-	fieldNameURI            = "URI"
-	fieldNameFilename       = "Filename"
-	fieldNameSize           = "Size"
-	fieldNameLastModified   = "Last-Modified"
-	fieldNameMessage        = "Message"
-	fieldNameMD5Hash        = "MD5-Hash"
-	fieldNameMD5SumHash     = "MD5Sum-Hash"
-	fieldNameSHA1Hash       = "SHA1-Hash"
-	fieldNameSHA256Hash     = "SHA256-Hash"
-	fieldNameSHA512Hash     = "SHA512-Hash"
-)
-
-const (
-	fieldValueTrue       = "true"
-	fieldValueYes        = "yes"
 	fieldValueNotFound   = "The specified key does not exist."
-	fieldValueConnecting = "Connecting to s3.amazonaws.com"
+	fieldValueConnecting = "Connecting to %s"
+	fieldValueCertError  = "Certificate verification failed: %v. " +
+		"Set Acquire::s3::CaInfo to a CA bundle that includes the CA of the S3 endpoint"
 )
 
 const (
-	configItemAcquireS3Region = "Acquire::s3::region"
-	configItemAcquireS3Role   = "Acquire::s3::role"
+	failReasonHTTPErrorPrefix   = "HttpError"
+	failReasonNotFound          = "HttpError404"
+	failReasonTimeout           = "Timeout"
+	failReasonConnectionRefused = "ConnectionRefused"
+	failReasonResolveFailure    = "ResolveFailure"
+	failReasonTmpResolveFailure = "TmpResolveFailure"
+	failReasonHashSumMismatch   = "HashSumMismatch"
 )
 
 const (
@@ -112,122 +67,221 @@ const (
 
 var (
 	errLocMissingRequiredTokens           = errors.New("location missing required number of tokens")
-	errAcqMsgMissingRequiredFieldURI      = errors.New("acquire message missing required field: URI")
-	errAcqMsgMissingRequiredFieldFilename = errors.New("acquire message missing required field: Filename")
 	errAcqMsgMissingRequiredFieldPassword = errors.New("acquire message missing required value: Password")
+	errEndpointFIPSAccelerate             = errors.New("S3 Transfer Acceleration has no FIPS endpoints")
+	errCAInfoNoCertificates               = errors.New("no certificates found")
+	errWebIdentityWithoutRole             = errors.New("web identity token file configured without a role to assume")
+	errSSOProfile                         = errors.New("profiles using SSO are not supported")
 )
 
 // A Method implements the logic to process incoming apt messages and respond
 // accordingly.
 type Method struct {
-	region, roleARN string
-	msgChan         chan []byte
-	configured      bool
-	wg              *sync.WaitGroup
-	stdout          *log.Logger
+	msgChan chan *message.Message
+	wg      *sync.WaitGroup
+	stdout  *message.Writer
+
+	// ctx is cancelled when messages can no longer be written to APT, which
+	// stops all in-flight requests to S3.
+	//
+	//nolint:containedctx
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// config is published exactly once through publishConfig, after which
+	// configured is closed. It must not be read before then.
+	config        *config
+	configOnce    sync.Once
+	configured    chan struct{}
+	configTimeout time.Duration
+
+	clients *clientCache
+	regions *regionCache
+}
+
+// An Option customizes a Method created with New.
+type Option func(*Method)
+
+// WithConfigTimeout sets how long the Method waits for the Configuration
+// message from APT before falling back to its default configuration. It
+// defaults to DefaultConfigTimeout.
+func WithConfigTimeout(timeout time.Duration) Option {
+	return func(method *Method) {
+		method.configTimeout = timeout
+	}
 }
 
-// New returns a new Method configured to read from os.Stdin and write to
-// the given *log.Logger.
-func New(logger *log.Logger) *Method {
+// New returns a new Method configured to read from os.Stdin and write
+// messages to the output of the given *log.Logger.
+func New(logger *log.Logger, opts ...Option) *Method {
 	var waitGroup sync.WaitGroup
 	waitGroup.Add(1)
-	return &Method{
-		region:     endpoints.UsEast1RegionID,
-		msgChan:    make(chan []byte),
-		configured: false,
-		wg:         &waitGroup,
-		stdout:     logger,
+	ctx, cancel := context.WithCancel(context.Background())
+	method := &Method{
+		msgChan:       make(chan *message.Message),
+		wg:            &waitGroup,
+		stdout:        message.NewWriter(logger.Writer()),
+		ctx:           ctx,
+		cancel:        cancel,
+		configured:    make(chan struct{}),
+		configTimeout: DefaultConfigTimeout,
+		clients:       newClientCache(newS3Client),
+		regions:       newRegionCache(lookupBucketRegion),
+	}
+	for _, opt := range opts {
+		opt(method)
 	}
+	return method
 }
 
 // Run flushes the Method's capabilities and then begins reading messages from
 // os.Stdin. Results are written to os.Stdout. The running Method waits for all
-// Messages to be processed before exiting.
-func (method *Method) Run() {
+// Messages to be processed before exiting. If writing to os.Stdout fails, any
+// in-flight downloads are cancelled and Run returns the write error.
+func (method *Method) Run() error {
 	method.flushCapabilities()
 	go method.readInput(os.Stdin)
 	go method.processMessages()
-	method.wg.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		method.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-method.ctx.Done():
+	}
+	return method.stdout.Err()
 }
 
 func (method *Method) flushCapabilities() {
-	msg := capabilities()
-	method.stdout.Println(msg)
+	method.output(capabilities())
 }
 
-// readInput reads from the provided io.Reader and flushes each message to the
-// Method's Message channel for processing. It stops reading when io.Reader is
-// empty. Each message increments the Method's sync.WaitGroup by 1. Once all
-// messages have been read from the io.Reader, the Method's sync.WaitGroup is
+// readInput decodes messages from the provided io.Reader and sends each one to
+// the Method's Message channel for processing, until the io.Reader is empty.
+// Each message increments the Method's sync.WaitGroup by 1. Once all messages
+// have been read from the io.Reader, the Method's sync.WaitGroup is
 // decremented by 1. Each code path that processes a message is responsible for
 // decrementing the WaitGroup when the code path terminates.
+//
+// Malformed field lines are logged and skipped, and so are whole messages that
+// cannot be parsed, e.g. because of a malformed header, so that bad input does
+// not take down the Method.
 func (method *Method) readInput(input io.Reader) {
-	scanner := bufio.NewScanner(input)
-	buffer := &bytes.Buffer{}
+	decoder := message.NewDecoder(input, message.Lenient(func(err *message.ParseError) {
+		method.outputGeneralLog(fmt.Sprintf("Skipping malformed input: %v", err))
+	}))
 	for {
-		hasLine := scanner.Scan()
-		if hasLine {
-			line := scanner.Text() + "\n"
-			buffer.WriteString(line)
-			trimmed := strings.TrimRight(line, "\n")
-
-			// Messages are terminated with a blank line. If a line with no content
-			// comes in and the buffer already has some content, it's assuming that
-			// the buffer currently contains a complete message ready to be processed.
-			if len(trimmed) == 0 && buffer.Len() > 3 {
-				method.msgChan <- buffer.Bytes()
-				method.wg.Add(1)
-				buffer = &bytes.Buffer{}
-			}
-		} else {
+		msg, err := decoder.Decode()
+		if errors.Is(err, io.EOF) {
 			break
 		}
+		var decodeErr *message.DecodeError
+		if errors.As(err, &decodeErr) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			method.outputGeneralLog(fmt.Sprintf("Skipping malformed message: %v", err))
+			continue
+		}
+		// A truncated message or a broken input stream leave the Method with
+		// no way to communicate with APT, so they are treated as fatal.
+		if err != nil {
+			method.handleError(fmt.Errorf("reading input: %w", err))
+		}
+		method.wg.Add(1)
+		method.msgChan <- msg
 	}
 	method.wg.Done()
 }
 
-func capabilities() *message.Message {
-	header := header(headerCodeCapabilities, headerDescriptionCapabilities)
-	fields := []*message.Field{
-		field(fieldNameSendConfig, fieldValueTrue),
-		field(fieldNamePipeline, fieldValueTrue),
-		field(fieldNameSingleInstance, fieldValueYes),
-	}
-	return &message.Message{Header: header, Fields: fields}
+func capabilities() message.Capabilities {
+	return message.Capabilities{SendConfig: true, Pipeline: true, SingleInstance: true}
 }
 
-// processMessages loops over the channel of Messages
-// and starts a goroutine to process each Message.
+// processMessages loops over the channel of Messages and dispatches each
+// Message according to the Message.Header.Status value. URI Acquire messages
+// are queued in the order they were received and handed to a bounded pool of
+// workers, so that the queue never blocks reading further messages. The
+// workers report their URI Start messages in queue order.
 func (method *Method) processMessages() {
+	acquires := make(chan *acquireJob)
+	go method.startWorkers(acquires)
+
+	var queue []*acquireJob
+	// The first acquire can report its URI Start straight away.
+	prev := make(chan struct{})
+	close(prev)
 	for {
-		bytes := <-method.msgChan
-		go method.handleBytes(bytes)
+		// Sending on a nil channel blocks, so the queue is only drained while
+		// it has something in it.
+		var next chan<- *acquireJob
+		var head *acquireJob
+		if len(queue) > 0 {
+			next, head = acquires, queue[0]
+		}
+
+		select {
+		case msg := <-method.msgChan:
+			switch msg.Header.Status {
+			case message.StatusURIAcquire:
+				job := &acquireJob{msg: msg, turn: &startTurn{prev: prev, done: make(chan struct{})}}
+				prev = job.turn.done
+				queue = append(queue, job)
+			case message.StatusConfiguration:
+				method.configure(msg)
+			}
+		case next <- head:
+			queue = queue[1:]
+		}
 	}
 }
 
-// handleBytes initializes a new Message and dispatches it according to
-// the Message.Header.Status value.
-func (method *Method) handleBytes(b []byte) {
-	msg, err := message.FromBytes(b)
-	method.handleError(err)
-	if msg.Header.Status == headerCodeURIAcquire {
-		// URI Acquire message
-		method.uriAcquire(msg)
-	} else if msg.Header.Status == headerCodeConfiguration {
-		// Configuration message
-		method.configure(msg)
+// An acquireJob is a URI Acquire message queued for the pool of workers.
+type acquireJob struct {
+	msg  *message.Message
+	turn *startTurn
+}
+
+// A startTurn makes acquires report their 200 URI Start messages in the order
+// APT sent the requests, even though the workers of the pool send their HEAD
+// requests concurrently. Each acquire waits for the one queued before it to
+// report its URI Start, or to finish without one, before reporting its own.
+//
+// Workers take jobs from the queue in order, so the acquire waited for is
+// always already being worked on.
+type startTurn struct {
+	prev <-chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// wait blocks until it is the acquire's turn to report its URI Start. A nil
+// startTurn never blocks.
+func (turn *startTurn) wait() {
+	if turn != nil {
+		<-turn.prev
 	}
 }
 
-// waitForConfiguration ensures that the configuration Message from APT
-// has been fully processed before continuing.
-func (method *Method) waitForConfiguration() {
-	for {
-		if method.configured {
-			return
-		}
-		time.Sleep(1 * time.Millisecond)
+// release passes the turn on to the next acquire. It may be called more than
+// once.
+func (turn *startTurn) release() {
+	if turn != nil {
+		turn.once.Do(func() { close(turn.done) })
+	}
+}
+
+// startWorkers waits for the configuration and then starts the configured
+// number of workers, each of which acquires one URI at a time from the given
+// channel.
+func (method *Method) startWorkers(acquires <-chan *acquireJob) {
+	cfg := method.waitForConfiguration()
+	for i := 0; i < cfg.maxParallel; i++ {
+		go func() {
+			for job := range acquires {
+				method.uriAcquire(job.msg, job.turn)
+			}
+		}()
 	}
 }
 
@@ -238,19 +292,41 @@ type objectLocation struct {
 	key    string
 }
 
-func newLocation(value, s3Hostname string) (objectLocation, error) {
+// newLocation parses the given URI into an objectLocation. s3Hosts are the
+// hosts, including any port, of the S3 endpoints the URI may be served from.
+// URIs may name the bucket in their path, e.g.
+// s3://minio.internal:9000/bucket/key, in their host as a subdomain of an
+// endpoint, e.g. s3://bucket.s3-accelerate.amazonaws.com/key, or as their
+// host, e.g. s3://bucket/key.
+func newLocation(value string, s3Hosts ...string) (objectLocation, error) {
 	uri, err := url.Parse(preProcessURL(value))
 	if err != nil {
 		return objectLocation{}, err
 	}
-	if uri.Host == s3Hostname {
+	for _, s3Host := range s3Hosts {
+		if objLoc, ok, err := hostLocation(uri, s3Host); ok {
+			return objLoc, err
+		}
+	}
+
+	return objectLocation{
+		uri:    uri,
+		bucket: uri.Host,
+		key:    uri.Path[1:],
+	}, nil
+}
+
+// hostLocation returns the objectLocation of the URI if it is served from the
+// S3 endpoint with the given host. It reports whether it is.
+func hostLocation(uri *url.URL, s3Host string) (objectLocation, bool, error) {
+	if uri.Host == s3Host {
 		tokens := strings.Split(uri.Path, "/")
 
 		// Splitting "/bucket/this/is/a/path" on "/" produces
 		// ["", "bucket", "this", "is", "a", "path"]
 		// Note the initial empty string
 		if len(tokens) < locationMinTokensCount {
-			return objectLocation{}, errLocMissingRequiredTokens
+			return objectLocation{}, true, errLocMissingRequiredTokens
 		}
 
 		// The first non-zero length string is assumed to be the bucket. The rest are
@@ -259,22 +335,75 @@ func newLocation(value, s3Hostname string) (objectLocation, error) {
 			uri:    uri,
 			bucket: tokens[1],
 			key:    strings.Join(tokens[2:], "/"),
-		}, nil
+		}, true, nil
 	}
 
-	if strings.HasSuffix(uri.Host, s3Hostname) {
+	if strings.HasSuffix(uri.Host, "."+s3Host) {
 		return objectLocation{
 			uri:    uri,
-			bucket: strings.TrimSuffix(uri.Host, "."+s3Hostname),
+			bucket: strings.TrimSuffix(uri.Host, "."+s3Host),
 			key:    uri.Path[1:],
-		}, nil
+		}, true, nil
 	}
 
-	return objectLocation{
-		uri:    uri,
-		bucket: uri.Host,
-		key:    uri.Path[1:],
-	}, nil
+	return objectLocation{}, false, nil
+}
+
+// locate parses the given URI into an objectLocation and returns the config
+// for the bucket it refers to.
+//
+// Which part of the URI names the bucket depends on the S3 hostname, which in
+// turn may depend on the bucket's own settings. The URI is therefore parsed
+// with the global endpoint first and, if that does not yield a bucket with
+// settings of its own, with the endpoint of each configured bucket.
+func locate(cfg *config, uri string) (*config, objectLocation, error) {
+	parsed, err := url.Parse(preProcessURL(uri))
+	if err != nil {
+		return nil, objectLocation{}, err
+	}
+	cfg = cfg.forHost(parsed.Host)
+
+	s3Hosts, err := cfg.s3Hosts()
+	if err != nil {
+		return nil, objectLocation{}, err
+	}
+	objLoc, err := newLocation(uri, s3Hosts...)
+	if err != nil {
+		return nil, objectLocation{}, err
+	}
+	if bucketCfg, ok := cfg.forBucket(objLoc.bucket); ok {
+		return bucketCfg, objLoc, nil
+	}
+
+	for bucket := range cfg.buckets {
+		bucketCfg, _ := cfg.forBucket(bucket)
+		bucketHosts, err := bucketCfg.s3Hosts()
+		if err != nil {
+			continue
+		}
+		if bucketLoc, err := newLocation(uri, bucketHosts...); err == nil && bucketLoc.bucket == bucket {
+			return bucketCfg, bucketLoc, nil
+		}
+	}
+	return cfg, objLoc, nil
+}
+
+// connect reports to APT that the Method is connecting to S3 and returns the
+// client to use for the bucket, along with the config it was built from. If
+// the region of the bucket was discovered earlier, that region is used.
+func (method *Method) connect(cfg *config, uri string, user *url.Userinfo, bucket string) (*config, s3iface.S3API, error) {
+	cfg = method.regions.forBucket(cfg, bucket)
+	s3URL, err := cfg.endpointURL()
+	if err != nil {
+		return nil, nil, err
+	}
+	method.outputRequestStatus(uri, fmt.Sprintf(fieldValueConnecting, s3URL.Host))
+
+	client, err := method.s3Client(cfg, user)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, client, nil
 }
 
 // Replace any forward slashes in access key and secret.
@@ -303,109 +432,247 @@ func preProcessURL(url string) string {
 }
 
 // uriAcquire downloads and stores objects from S3 based on the contents
-// of the provided Message.
-func (method *Method) uriAcquire(msg *message.Message) {
-	method.waitForConfiguration()
+// of the provided Message. Errors encountered while acquiring the object are
+// reported to APT as a URI Failure so that other pipelined requests can
+// continue to be served. The acquire reports its URI Start only once the
+// given turn comes up, and passes the turn on when it returns.
+func (method *Method) uriAcquire(msg *message.Message, turn *startTurn) {
+	defer turn.release()
+	cfg := method.waitForConfiguration()
+
+	// Without a URI there is nothing to report a URI Failure for, so only that
+	// is fatal.
+	var req message.URIAcquire
+	if err := message.Unmarshal(msg, &req); err != nil {
+		if req.URI == "" {
+			method.handleError(err)
+		}
+		method.outputURIFailure(req.URI, err)
+		return
+	}
 
-	uri, hasField := msg.GetFieldValue(fieldNameURI)
-	if !hasField {
-		method.handleError(errAcqMsgMissingRequiredFieldURI)
+	if err := method.acquire(cfg, req, turn); err != nil {
+		method.outputURIFailure(req.URI, err)
 	}
+}
 
-	s3URL, err := s3EndpointURL(method.region)
+// acquire does the work of uriAcquire for the given request. Any error it
+// returns is scoped to the URI being acquired.
+func (method *Method) acquire(cfg *config, req message.URIAcquire, turn *startTurn) error {
+	obj, err := method.headObject(cfg, req)
 	if err != nil {
-		method.handleError(fmt.Errorf("resolving S3 endpoint for region %s: %w", method.region, err))
+		if method.reportUnchangedOrMissing(req, err) {
+			return nil
+		}
+		return err
 	}
+	return method.download(obj, req, turn)
+}
 
-	objLoc, err := newLocation(uri, s3URL.Hostname())
-	method.handleError(err)
+// A remoteObject is an object that headObject found in S3, along with the
+// client and config to download it with.
+type remoteObject struct {
+	cfg    *config
+	client s3iface.S3API
+	loc    objectLocation
+	head   *s3.HeadObjectOutput
+}
 
-	method.outputRequestStatus(objLoc.uri, fieldValueConnecting)
+// headObject looks up the object requested by req with a HEAD request. If the
+// bucket turns out to be in another region, the request is retried there.
+func (method *Method) headObject(cfg *config, req message.URIAcquire) (remoteObject, error) {
+	cfg, objLoc, err := locate(cfg, req.URI)
+	if err != nil {
+		return remoteObject{}, err
+	}
+	user, err := requestUser(cfg, objLoc)
+	if err != nil {
+		return remoteObject{}, err
+	}
+	cfg, client, err := method.connect(cfg, req.URI, user, objLoc.bucket)
+	if err != nil {
+		return remoteObject{}, err
+	}
 
-	client := method.s3Client(objLoc.uri.User)
+	input := &s3.HeadObjectInput{Bucket: &objLoc.bucket, Key: &objLoc.key}
 
-	headObjectInput := &s3.HeadObjectInput{Bucket: &objLoc.bucket, Key: &objLoc.key}
-	headObjectOutput, err := client.HeadObject(headObjectInput)
-	if err != nil {
-		//nolint:errorlint
-		if reqErr, ok := err.(awserr.RequestFailure); ok {
-			if reqErr.StatusCode() == http.StatusNotFound {
-				method.outputNotFound(objLoc.uri)
-				return
-			}
-			// if the error is an awserr.RequestFailure, but the status was not 404
-			// handle the error
-			method.handleError(err)
-		} else {
-			method.handleError(err)
+	// APT sends the Last-Modified time of files it already has, in which case
+	// the object only needs to be downloaded if it has changed since then.
+	if !req.LastModified.IsZero() {
+		input.IfModifiedSince = aws.Time(req.LastModified)
+	}
+
+	output, err := client.HeadObjectWithContext(method.ctx, input)
+	if err != nil && method.discoverRegion(cfg, client, objLoc.bucket, err) {
+		// The bucket's region is known now, so connecting again uses it.
+		if cfg, client, err = method.connect(cfg, req.URI, user, objLoc.bucket); err != nil {
+			return remoteObject{}, err
 		}
+		output, err = client.HeadObjectWithContext(method.ctx, input)
+	}
+	if err != nil {
+		return remoteObject{}, err
 	}
+	return remoteObject{cfg: cfg, client: client, loc: objLoc, head: output}, nil
+}
 
-	expectedLen := *headObjectOutput.ContentLength
-	lastModified := *headObjectOutput.LastModified
-	method.outputURIStart(objLoc.uri, expectedLen, lastModified)
+// requestUser returns the credentials to request the object at objLoc with:
+// those in the URI, or else any found in APT's auth.conf files. Credentials
+// found in auth.conf are deliberately not added to the URI, which is echoed
+// back to APT.
+func requestUser(cfg *config, objLoc objectLocation) (*url.Userinfo, error) {
+	if objLoc.uri.User != nil {
+		return objLoc.uri.User, nil
+	}
+	user, _, err := netrcCredentials(cfg, objLoc.uri)
+	return user, err
+}
+
+// reportUnchangedOrMissing reports the outcomes of a HEAD request that are
+// not failures: an object that has not been modified since APT's copy of it,
+// and one that does not exist. It reports whether err was one of them.
+func (method *Method) reportUnchangedOrMissing(req message.URIAcquire, err error) bool {
+	var reqErr awserr.RequestFailure
+	if !errors.As(err, &reqErr) {
+		return false
+	}
+	switch reqErr.StatusCode() {
+	case http.StatusNotModified:
+		method.outputIMSHit(req.URI, req.Filename, req.LastModified)
+		return true
+	case http.StatusNotFound:
+		method.outputNotFound(req.URI)
+		return true
+	}
+	return false
+}
+
+// download downloads obj to the file requested by req, resuming an earlier
+// download if possible, and reports the result to APT once the file has been
+// verified.
+func (method *Method) download(obj remoteObject, req message.URIAcquire, turn *startTurn) error {
+	expectedLen := *obj.head.ContentLength
+	lastModified := *obj.head.LastModified
 
-	filename, hasField := msg.GetFieldValue(fieldNameFilename)
-	if !hasField {
-		method.handleError(errAcqMsgMissingRequiredFieldFilename)
+	file, resumePoint, err := openPartial(req.Filename, expectedLen, lastModified)
+	if err != nil {
+		return err
 	}
-	file, err := os.Create(filename)
-	method.handleError(err)
 	defer file.Close()
 
-	downloader := s3manager.NewDownloaderWithClient(client)
-	numBytes, err := downloader.Download(file,
-		&s3.GetObjectInput{
-			Bucket: aws.String(objLoc.bucket),
-			Key:    aws.String(objLoc.key),
-		})
-	method.handleError(err)
+	turn.wait()
+	method.outputURIStart(req.URI, expectedLen, lastModified, resumePoint)
+	turn.release()
 
-	method.outputURIDone(objLoc.uri, numBytes, lastModified, filename)
+	sink, err := newHashingWriter(file, resumePoint)
+	if err != nil {
+		return err
+	}
+	numBytes, err := method.getObject(obj, req.URI, sink, resumePoint)
+	if err != nil {
+		return err
+	}
+	size := resumePoint + numBytes
+	sums, err := sink.digests(size)
+	if err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := verifyDownload(req, sums); err != nil {
+		return err
+	}
+
+	method.outputURIDone(req.URI, size, lastModified, req.Filename, sums)
+	return nil
+}
+
+// getObject writes the content of obj from resumePoint on to w, reporting its
+// progress to APT. It returns the number of bytes written.
+func (method *Method) getObject(obj remoteObject, uri string, w io.WriterAt, resumePoint int64) (int64, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(obj.loc.bucket),
+		Key:    aws.String(obj.loc.key),
+	}
+	if resumePoint > 0 {
+		// Only fetch the remainder of the object, and only if it is still the
+		// same object that was inspected above.
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-", resumePoint))
+		input.IfMatch = obj.head.ETag
+	}
+
+	progress := newProgressWriter(w, *obj.head.ContentLength, resumePoint, progressInterval, func(status string) {
+		method.outputRequestStatus(uri, status)
+	})
+	downloader := s3manager.NewDownloaderWithClient(obj.client, func(d *s3manager.Downloader) {
+		d.Concurrency = obj.cfg.partConcurrency
+	})
+	return downloader.DownloadWithContext(method.ctx, progress, input)
+}
+
+// verifyDownload checks the downloaded file against the hashes APT expects.
+// A file that does not match is removed, so that it is never handed back to
+// APT.
+func verifyDownload(req message.URIAcquire, sums digests) error {
+	err := sums.verify(req)
+	if err == nil {
+		return nil
+	}
+	if rmErr := os.Remove(req.Filename); rmErr != nil {
+		return fmt.Errorf("%w (removing %s: %v)", err, req.Filename, rmErr)
+	}
+	return err
 }
 
-// s3Client provides an initialized s3iface.S3API based on the contents of the
-// provided url.URL. The access key id and secret access key are assumed to
-// correspond to the Username() and Password() functions on the URL's User.
-func (method *Method) s3Client(user *url.Userinfo) s3iface.S3API {
-	config := &aws.Config{
-		Region: aws.String(method.region),
+// openPartial opens the file at filename for writing the object being
+// acquired. If the file holds the beginning of the object from an earlier,
+// interrupted download, its length is returned as the point to resume the
+// download from. Otherwise the file is truncated and the returned resume point
+// is 0.
+//
+// Like APT's http method, the modification time of the partial file is used to
+// decide whether it is still valid: the object must not have been modified
+// after the partial file was last written to.
+func openPartial(filename string, size int64, lastModified time.Time) (*os.File, int64, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0o666)
+	if err != nil {
+		return nil, 0, err
 	}
-	sess, err := session.NewSession(config)
+	info, err := file.Stat()
 	if err != nil {
-		method.handleError(fmt.Errorf("creating AWS session: %w", err))
-	}
-	if accessKeyID := user.Username(); accessKeyID != "" {
-		// Use explicitly specified static credentials to access S3
-		if secretAccessKey, ok := user.Password(); ok {
-			config.Credentials = credentials.NewStaticCredentials(accessKeyID, secretAccessKey, "")
-		} else {
-			method.handleError(errAcqMsgMissingRequiredFieldPassword)
-		}
-	} else if method.roleARN != "" {
-		// Use default credential chain to assume specified role
-		config.Credentials = stscreds.NewCredentials(sess, method.roleARN)
+		file.Close()
+		return nil, 0, err
 	}
 
-	return s3.New(sess, config)
+	resumePoint := info.Size()
+	if resumePoint >= size || lastModified.After(info.ModTime()) {
+		resumePoint = 0
+	}
+	if err := file.Truncate(resumePoint); err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, resumePoint, nil
 }
 
-// configure loops though the Config-Item fields of a configuration Message and
-// sets the appropriate state on the Method based on the field values. Once the
+// configure builds a config from the Config-Item fields of a configuration
+// Message and publishes it to the acquires waiting for it. Once the
 // configuration has been applied, the Method's sync.WaitGroup is decremented
 // by 1.
 func (method *Method) configure(msg *message.Message) {
-	items := msg.GetFieldList(fieldNameConfigItem)
-	for _, f := range items {
-		config := strings.Split(f.Value, "=")
-		switch config[0] {
-		case configItemAcquireS3Region:
-			method.region = config[1]
-		case configItemAcquireS3Role:
-			method.roleARN = config[1]
-		}
+	var configuration message.Configuration
+	if err := message.Unmarshal(msg, &configuration); err != nil {
+		method.handleError(err)
+	}
+	tree, err := aptconfig.Parse(configuration.ConfigItems)
+	if err != nil {
+		method.outputGeneralLog(fmt.Sprintf("Ignoring %v", err))
+	}
+	cfg := newConfig(tree)
+	if !method.publishConfig(cfg) {
+		method.outputGeneralLog("Ignoring configuration received after the defaults were applied")
 	}
-	method.configured = true
 	method.wg.Done()
 }
 
@@ -415,11 +682,8 @@ func (method *Method) configure(msg *message.Message) {
 // 102 Status
 // URI: s3://fake-access-key-id:fake-secret-access-key@s3.amazonaws.com/bucket-name/apt/trusty/riemann-sumd_0.7.2-1_all.deb
 // Message: Connecting to s3.amazonaws.com
-func requestStatus(s3Uri *url.URL, status string) *message.Message {
-	h := header(headerCodeStatus, headerDescriptionStatus)
-	uriField := field(fieldNameURI, s3Uri.String())
-	messageField := field(fieldNameMessage, status)
-	return &message.Message{Header: h, Fields: []*message.Field{uriField, messageField}}
+func requestStatus(uri string, status string) message.Status {
+	return message.Status{URI: uri, Message: redact(status)}
 }
 
 // uriStart constructs a Message that when printed looks like the following
@@ -429,12 +693,12 @@ func requestStatus(s3Uri *url.URL, status string) *message.Message {
 // URI: s3://fake-access-key-id:fake-secret-access-key@s3.amazonaws.com/bucket-name/apt/trusty/riemann-sumd_0.7.2-1_all.deb
 // Size: 9012
 // Last-Modified: Thu, 25 Oct 2018 20:17:39 GMT
-func (method *Method) uriStart(s3Uri *url.URL, size int64, t time.Time) *message.Message {
-	h := header(headerCodeURIStart, headerDescriptionURIStart)
-	uriField := field(fieldNameURI, s3Uri.String())
-	sizeField := field(fieldNameSize, strconv.FormatInt(size, 10))
-	lmField := method.lastModified(t)
-	return &message.Message{Header: h, Fields: []*message.Field{uriField, sizeField, lmField}}
+// Resume-Point: 4096
+//
+// The Resume-Point field is only included when an interrupted download is
+// being resumed.
+func uriStart(uri string, size int64, t time.Time, resumePoint int64) message.URIStart {
+	return message.URIStart{URI: uri, Size: size, LastModified: t, ResumePoint: resumePoint}
 }
 
 // uriDone constructs a Message that when printed looks like the following
@@ -452,40 +716,100 @@ func (method *Method) uriStart(s3Uri *url.URL, size int64, t time.Time) *message
 // SHA512-Hash: ab3b1c94618cb58e2147db1c1d4bd3472f17fb11b1361e77216b461ab7d5f5952a5c6bb0443a1507d8ca5ef1eb18ac7552d0f2a537a0d44b8612d7218bf379fb
 //
 //nolint:lll
-func (method *Method) uriDone(s3Uri *url.URL, size int64, t time.Time, filename string) *message.Message {
-	uriField := field(fieldNameURI, s3Uri.String())
-	filenameField := field(fieldNameFilename, filename)
-	sizeField := field(fieldNameSize, strconv.FormatInt(size, 10))
-	lmField := method.lastModified(t)
-	fileBytes, err := os.ReadFile(filename)
-	method.handleError(err)
-
-	fields := []*message.Field{
-		uriField,
-		filenameField,
-		sizeField,
-		lmField,
-		method.md5Field(fileBytes),
-		method.md5SumField(fileBytes),
-		method.sha1Field(fileBytes),
-		method.sha256Field(fileBytes),
-		method.sha512Field(fileBytes),
+func uriDone(uri string, size int64, t time.Time, filename string, sums digests) message.URIDone {
+	return message.URIDone{
+		URI:          uri,
+		Filename:     filename,
+		Size:         size,
+		LastModified: t,
+		MD5Hash:      sums.md5,
+		MD5SumHash:   sums.md5,
+		SHA1Hash:     sums.sha1,
+		SHA256Hash:   sums.sha256,
+		SHA512Hash:   sums.sha512,
 	}
+}
 
-	return &message.Message{Header: header(headerCodeURIDone, headerDescriptionURIDone), Fields: fields}
+// imsHit constructs a Message that when printed looks like the following
+// example:
+//
+// 201 URI Done
+// URI: s3://fake-access-key-id:fake-secret-access-key@s3.amazonaws.com/bucket-name/apt/dists/trusty/main/binary-amd64/Packages
+// Filename: /var/lib/apt/lists/partial/bucket-name_apt_dists_trusty_main_binary-amd64_Packages
+// Last-Modified: Thu, 25 Oct 2018 20:17:39 GMT
+// IMS-Hit: true
+//
+//nolint:lll
+func imsHit(uri string, filename string, t time.Time) message.URIDone {
+	return message.URIDone{URI: uri, Filename: filename, LastModified: t, IMSHit: true}
 }
 
 // notFound constructs a Message that when printed looks like the following
 // example:
 //
 // 400 URI Failure
+// URI: s3://fake-access-key-id:fake-secret-access-key@s3.amazonaws.com/bucket-name/apt/trusty/riemann-sumd_0.7.2-1_all.deb
 // Message: The specified key does not exist.
+// FailReason: HttpError404
+func notFound(uri string) message.URIFailure {
+	return message.URIFailure{URI: uri, Message: fieldValueNotFound, FailReason: failReasonNotFound}
+}
+
+// uriFailure constructs a Message that when printed looks like the following
+// example:
+//
+// 400 URI Failure
 // URI: s3://fake-access-key-id:fake-secret-access-key@s3.amazonaws.com/bucket-name/apt/trusty/riemann-sumd_0.7.2-1_all.deb
-func notFound(s3Uri *url.URL) *message.Message {
-	h := header(headerCodeURIFailure, headerDescriptionURIFailure)
-	uriField := field(fieldNameURI, s3Uri.String())
-	messageField := field(fieldNameMessage, fieldValueNotFound)
-	return &message.Message{Header: h, Fields: []*message.Field{uriField, messageField}}
+// Message: Forbidden: Forbidden status code: 403, request id: ...
+// FailReason: HttpError403
+//
+// The FailReason field is omitted when the error does not map to a reason
+// understood by APT.
+func uriFailure(uri string, err error) message.URIFailure {
+	text := err.Error()
+	if certErr := certificateError(err); certErr != nil {
+		text = fmt.Sprintf(fieldValueCertError, certErr)
+	}
+	return message.URIFailure{
+		URI:        uri,
+		Message:    redact(strings.ReplaceAll(text, "\n", " "), uriSecrets(uri)...),
+		FailReason: failReason(err),
+	}
+}
+
+// failReason maps an error encountered while acquiring a URI to one of the
+// FailReason values APT knows how to interpret. It returns an empty string if
+// no reason applies.
+func failReason(err error) string {
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) {
+		return failReasonHTTPErrorPrefix + strconv.Itoa(reqErr.StatusCode())
+	}
+	// awserr.Error values do not support unwrapping, so their underlying
+	// errors need to be inspected explicitly.
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.OrigErr() != nil {
+		return failReason(awsErr.OrigErr())
+	}
+	var mismatchErr *hashSumMismatchError
+	if errors.As(err, &mismatchErr) {
+		return failReasonHashSumMismatch
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		if dnsErr.IsTemporary {
+			return failReasonTmpResolveFailure
+		}
+		return failReasonResolveFailure
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return failReasonTimeout
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return failReasonConnectionRefused
+	}
+	return ""
 }
 
 // generalLog constructs a Message that when printed looks like the following
@@ -493,14 +817,8 @@ func notFound(s3Uri *url.URL) *message.Message {
 //
 // 101 Log
 // Message: Set the s3 region to us-west-1 based on Config-Item Acquire::s3:region.
-//
-// This function is unused, but it's part of the spec...
-//
-//nolint:unused
-func generalLog(status string) *message.Message {
-	h := header(headerCodeGeneralLog, headerDescriptionGeneralLog)
-	messageField := field(fieldNameMessage, status)
-	return &message.Message{Header: h, Fields: []*message.Field{messageField}}
+func generalLog(status string) message.Log {
+	return message.Log{Message: redact(status)}
 }
 
 // generalFailure constructs a Message that when printed looks like the
@@ -508,54 +826,60 @@ func generalLog(status string) *message.Message {
 //
 // 401 General Failure
 // Message: Error retrieving ...
-func generalFailure(err error) *message.Message {
-	h := header(headerCodeGeneralFailure, headerDescriptionGeneralFailure)
-	msg := strings.ReplaceAll(err.Error(), "\n", " ")
-	messageField := field(fieldNameMessage, msg)
-	return &message.Message{Header: h, Fields: []*message.Field{messageField}}
+func generalFailure(err error) message.GeneralFailure {
+	return message.GeneralFailure{Message: redact(strings.ReplaceAll(err.Error(), "\n", " "))}
 }
 
-func (method *Method) outputRequestStatus(s3Uri *url.URL, status string) {
-	msg := requestStatus(s3Uri, status)
-	method.stdout.Println(msg.String())
+func (method *Method) outputRequestStatus(uri string, status string) {
+	method.output(requestStatus(uri, status))
 }
 
-// This function is unused, but it's part of the spec...
-//
-//nolint:unused
 func (method *Method) outputGeneralLog(status string) {
-	msg := generalLog(status)
-	method.stdout.Println(msg.String())
+	method.output(generalLog(status))
 }
 
-func (method *Method) outputURIStart(s3Uri *url.URL, size int64, lastModified time.Time) {
-	msg := method.uriStart(s3Uri, size, lastModified)
-	method.stdout.Println(msg.String())
+func (method *Method) outputURIStart(uri string, size int64, lastModified time.Time, resumePoint int64) {
+	method.output(uriStart(uri, size, lastModified, resumePoint))
 }
 
 // outputURIDone prints a message including the details of the finished URI,
 // and subsequently decrements the Method's sync.WaitGroup by 1.
-func (method *Method) outputURIDone(s3Uri *url.URL, size int64, lastModified time.Time, filename string) {
-	msg := method.uriDone(s3Uri, size, lastModified, filename)
-	method.stdout.Println(msg.String())
+func (method *Method) outputURIDone(uri string, size int64, lastModified time.Time, filename string, sums digests) {
+	method.output(uriDone(uri, size, lastModified, filename, sums))
 	method.wg.Done()
 }
 
-// outputURIDone prints a message including the details of the URI that could
+// outputIMSHit prints a message telling APT that the copy of the URI it already
+// has is up to date, and subsequently decrements the Method's sync.WaitGroup
+// by 1.
+func (method *Method) outputIMSHit(uri string, filename string, lastModified time.Time) {
+	method.output(imsHit(uri, filename, lastModified))
+	method.wg.Done()
+}
+
+// outputNotFound prints a message including the details of the URI that could
 // not be found, and subsequently decrements the Method's sync.WaitGroup by 1.
-func (method *Method) outputNotFound(s3Uri *url.URL) {
-	msg := notFound(s3Uri)
-	method.stdout.Println(msg.String())
+func (method *Method) outputNotFound(uri string) {
+	method.output(notFound(uri))
+	method.wg.Done()
+}
+
+// outputURIFailure prints a message including the details of the URI that
+// could not be acquired, and subsequently decrements the Method's
+// sync.WaitGroup by 1.
+func (method *Method) outputURIFailure(uri string, err error) {
+	method.output(uriFailure(uri, err))
 	method.wg.Done()
 }
 
 func (method *Method) outputGeneralFailure(err error) {
-	msg := generalFailure(err)
-	method.stdout.Println(msg.String())
+	method.output(generalFailure(err))
 }
 
 // handleError writes the contents of the given error and then exits the
-// program, as specified in the APT method interface documentation.
+// program, as specified in the APT method interface documentation. It is
+// reserved for fatal errors; errors scoped to a single URI are reported with
+// outputURIFailure instead.
 func (method *Method) handleError(err error) {
 	if err != nil {
 		method.outputGeneralFailure(err)
@@ -563,59 +887,17 @@ func (method *Method) handleError(err error) {
 	}
 }
 
-func header(code int, description string) *message.Header {
-	return &message.Header{Status: code, Description: description}
-}
-
-func field(name string, value string) *message.Field {
-	return &message.Field{Name: name, Value: value}
-}
-
-// lastModified returns a Field with the given Time formatted using the RFC1123
-// specification in GMT, as specified in the APT method interface documentation.
-func (method *Method) lastModified(t time.Time) *message.Field {
-	gmt, err := time.LoadLocation("GMT")
-	method.handleError(err)
-	return field(fieldNameLastModified, t.In(gmt).Format(time.RFC1123))
-}
-
-func (method *Method) md5Field(bytes []byte) *message.Field {
-	md5 := md5.New()
-	md5String := method.computeHash(md5, bytes)
-	return field(fieldNameMD5Hash, md5String)
-}
-
-func (method *Method) md5SumField(bytes []byte) *message.Field {
-	md5 := md5.New()
-	md5String := method.computeHash(md5, bytes)
-	return field(fieldNameMD5SumHash, md5String)
-}
-
-func (method *Method) sha1Field(bytes []byte) *message.Field {
-	sha1 := sha1.New()
-	sha1String := method.computeHash(sha1, bytes)
-	return field(fieldNameSHA1Hash, sha1String)
-}
-
-func (method *Method) sha256Field(bytes []byte) *message.Field {
-	sha256 := sha256.New()
-	sha256String := method.computeHash(sha256, bytes)
-	return field(fieldNameSHA256Hash, sha256String)
-}
-
-func (method *Method) sha512Field(bytes []byte) *message.Field {
-	sha512 := sha512.New()
-	sha512String := method.computeHash(sha512, bytes)
-	return field(fieldNameSHA512Hash, sha512String)
-}
-
-func (method *Method) computeHash(h hash.Hash, fileBytes []byte) string {
-	method.prepareHash(h, fileBytes)
-	return hex.EncodeToString(h.Sum(nil))
-}
-
-func (method *Method) prepareHash(h hash.Hash, fileBytes []byte) {
-	if _, err := io.Copy(h, bytes.NewReader(fileBytes)); err != nil {
-		method.handleError(err)
+// output writes v to APT. If it cannot be written, APT can no longer be told
+// about the outcome of any request, so the Method's in-flight requests are
+// cancelled. A message that cannot be marshaled is a bug, which is logged to
+// stderr since it cannot be reported to APT, and the message is skipped.
+func (method *Method) output(v message.Typed) {
+	msg, err := message.Marshal(v)
+	if err != nil {
+		log.Printf("apt-golang-s3: %v", err)
+		return
+	}
+	if err := method.stdout.WriteMessage(msg); err != nil {
+		method.cancel()
 	}
 }
@@ -15,12 +15,27 @@
 package method
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+
+	"github.com/google/apt-golang-s3/message"
 )
 
 const (
@@ -56,7 +71,11 @@ Config-Item: Unattended-Upgrade::Allowed-Origins::=${distro_id}:${distro_codenam
 )
 
 func TestCapabilities(t *testing.T) {
-	actual := capabilities().String()
+	msg, err := message.Marshal(capabilities())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	actual := msg.String()
 	if actual != capMsg {
 		t.Errorf("capabilities() = %s; expected %s", actual, capMsg)
 	}
@@ -86,6 +105,73 @@ loop:
 	}
 }
 
+func TestOutputCancelsOnBrokenPipe(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer w.Close()
+	// With the read end closed, APT has gone away and nothing can be written.
+	r.Close()
+
+	method := New(log.New(w, "", 0))
+	method.output(generalLog("hello"))
+	select {
+	case <-method.ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("method.ctx was not cancelled after a write to a broken pipe")
+	}
+	if err := method.stdout.Err(); !errors.Is(err, syscall.EPIPE) {
+		t.Errorf("method.stdout.Err() = %v; expected %v", err, syscall.EPIPE)
+	}
+}
+
+func TestReadInputSkipsMalformedMessages(t *testing.T) {
+	out := &bytes.Buffer{}
+	method := New(log.New(out, "", 0))
+	input := "URI Acquire\nURI: s3://bucket/key\n\n600 URI Acquire\nnot a field\n\n" + acqMsg
+	go method.readInput(strings.NewReader(input))
+
+	var msgs int
+	for msgs < 2 {
+		select {
+		case <-method.msgChan:
+			msgs++
+		case <-time.After(time.Second):
+			t.Fatalf("Found %d messages; expected %d", msgs, 2)
+		}
+	}
+	if count := strings.Count(out.String(), "Skipping malformed message"); count != 2 {
+		t.Errorf("output = %s; expected 2 malformed messages to be skipped", out.String())
+	}
+}
+
+// unmarshalable is a message that cannot be marshaled.
+type unmarshalable struct {
+	Ratio float64 `message:"Ratio"`
+}
+
+func (unmarshalable) Header() message.Header {
+	return message.Header{Status: message.StatusLog, Description: "Log"}
+}
+
+func TestOutputLogsMarshalErrors(t *testing.T) {
+	stderr := &bytes.Buffer{}
+	log.SetOutput(stderr)
+	defer log.SetOutput(os.Stderr)
+
+	method := New(logger(t))
+	method.output(unmarshalable{Ratio: 0.5})
+	select {
+	case <-method.ctx.Done():
+		t.Errorf("method.ctx was cancelled after a marshaling error")
+	default:
+	}
+	if !strings.Contains(stderr.String(), "marshaling") {
+		t.Errorf("stderr = %q; expected the marshaling error to be logged", stderr.String())
+	}
+}
+
 func TestSettingRegion(t *testing.T) {
 	reader := strings.NewReader(configMsg)
 	method := New(logger(t))
@@ -93,24 +179,33 @@ func TestSettingRegion(t *testing.T) {
 
 	// consume the messages on the channel
 	for {
-		bytes := <-method.msgChan
-		method.handleBytes(bytes)
+		msg := <-method.msgChan
+		method.configure(msg)
 		if reader.Len() == 0 {
 			break
 		}
 	}
 	expected := "us-east-2"
-	if method.region != expected {
-		t.Errorf("method.region = %s; expected %s", method.region, expected)
+	if region := method.waitForConfiguration().region; region != expected {
+		t.Errorf("method.waitForConfiguration().region = %s; expected %s", region, expected)
 	}
 }
 
-func TestComputeHash(t *testing.T) {
-	method := New(logger(t))
-	hashed := method.computeHash(sha256.New(), []byte("hello"))
-	expected := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
-	if hashed != expected {
-		t.Errorf("method.computeHash(sha256.New(), []byte(\"hello\")) = %s; expected %s", hashed, expected)
+func TestConfigurationTimeout(t *testing.T) {
+	method := New(logger(t), WithConfigTimeout(time.Millisecond))
+	cfg := method.waitForConfiguration()
+	if cfg.region != "us-east-1" {
+		t.Errorf("cfg.region = %s; expected %s", cfg.region, "us-east-1")
+	}
+
+	// Configuration arriving after the timeout does not replace the defaults.
+	msg, err := message.FromBytes([]byte(configMsg))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	method.configure(msg)
+	if method.waitForConfiguration() != cfg {
+		t.Errorf("method.waitForConfiguration() changed after the configuration was published")
 	}
 }
 
@@ -120,6 +215,36 @@ type locTest struct {
 	accessKeySecret string
 }
 
+func TestStartTurn(t *testing.T) {
+	prev := make(chan struct{})
+	close(prev)
+	first := &startTurn{prev: prev, done: make(chan struct{})}
+	second := &startTurn{prev: first.done, done: make(chan struct{})}
+
+	started := make(chan struct{})
+	go func() {
+		second.wait()
+		close(started)
+	}()
+	select {
+	case <-started:
+		t.Fatal("second turn started before the first was released")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	first.release()
+	first.release()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("second turn did not start after the first was released")
+	}
+
+	var none *startTurn
+	none.wait()
+	none.release()
+}
+
 func TestCreateLocation(t *testing.T) {
 	locTests := []locTest{
 		{
@@ -164,6 +289,439 @@ func TestCreateLocation(t *testing.T) {
 	}
 }
 
+func TestLocate(t *testing.T) {
+	cfg := newConfig(configTree(t,
+		"Acquire::s3::region=us-east-1",
+		"Acquire::s3::Bucket::eu-bucket::region=eu-west-1",
+		"Acquire::s3::endpoint::minio.internal:9000=http://minio.internal:9000",
+	))
+
+	specs := map[string]struct {
+		uri            string
+		expectedBucket string
+		expectedRegion string
+		expectedHost   string
+	}{
+		"unscoped bucket": {
+			"s3://s3.amazonaws.com/us-bucket/dists/stable/Release",
+			"us-bucket",
+			"us-east-1",
+			"s3.amazonaws.com",
+		},
+		"scoped bucket": {
+			"s3://eu-bucket/dists/stable/Release",
+			"eu-bucket",
+			"eu-west-1",
+			"s3.eu-west-1.amazonaws.com",
+		},
+		"scoped bucket with regional path style host": {
+			"s3://s3.eu-west-1.amazonaws.com/eu-bucket/dists/stable/Release",
+			"eu-bucket",
+			"eu-west-1",
+			"s3.eu-west-1.amazonaws.com",
+		},
+		"scoped bucket with regional virtual host": {
+			"s3://eu-bucket.s3.eu-west-1.amazonaws.com/dists/stable/Release",
+			"eu-bucket",
+			"eu-west-1",
+			"s3.eu-west-1.amazonaws.com",
+		},
+		"custom endpoint": {
+			"s3://minio.internal:9000/minio-bucket/dists/stable/Release",
+			"minio-bucket",
+			"us-east-1",
+			"minio.internal:9000",
+		},
+		"custom endpoint with credentials": {
+			"s3://access-key:secret@minio.internal:9000/minio-bucket/dists/stable/Release",
+			"minio-bucket",
+			"us-east-1",
+			"minio.internal:9000",
+		},
+	}
+
+	for name, spec := range specs {
+		t.Run(name, func(t *testing.T) {
+			bucketCfg, objLoc, err := locate(cfg, spec.uri)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			s3URL, err := bucketCfg.endpointURL()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if objLoc.bucket != spec.expectedBucket {
+				t.Errorf("bucket = %s; expected %s", objLoc.bucket, spec.expectedBucket)
+			}
+			if objLoc.key != "dists/stable/Release" {
+				t.Errorf("key = %s; expected dists/stable/Release", objLoc.key)
+			}
+			if bucketCfg.region != spec.expectedRegion {
+				t.Errorf("region = %s; expected %s", bucketCfg.region, spec.expectedRegion)
+			}
+			if s3URL.Host != spec.expectedHost {
+				t.Errorf("host = %s; expected %s", s3URL.Host, spec.expectedHost)
+			}
+		})
+	}
+}
+
+func TestLocateWithEndpointOptions(t *testing.T) {
+	cfg := newConfig(configTree(t,
+		"Acquire::s3::region=us-east-1",
+		"Acquire::s3::use-accelerate=true",
+	))
+
+	specs := map[string]string{
+		"accelerate virtual host": "s3://apt-repo-bucket.s3-accelerate.amazonaws.com/dists/stable/Release",
+		"plain virtual host":      "s3://apt-repo-bucket.s3.amazonaws.com/dists/stable/Release",
+		"plain path style":        "s3://s3.amazonaws.com/apt-repo-bucket/dists/stable/Release",
+		"bucket host":             "s3://apt-repo-bucket/dists/stable/Release",
+	}
+
+	for name, uri := range specs {
+		t.Run(name, func(t *testing.T) {
+			_, objLoc, err := locate(cfg, uri)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if objLoc.bucket != "apt-repo-bucket" {
+				t.Errorf("bucket = %s; expected apt-repo-bucket", objLoc.bucket)
+			}
+			if objLoc.key != "dists/stable/Release" {
+				t.Errorf("key = %s; expected dists/stable/Release", objLoc.key)
+			}
+		})
+	}
+}
+
+func TestAcquireFromCustomEndpoint(t *testing.T) {
+	content := []byte("Origin: apt-golang-s3\nSuite: stable\n")
+	lastModified := time.Date(2018, time.October, 25, 20, 17, 39, 0, time.UTC)
+	// A minimal stand-in for an S3 compatible server with path-style
+	// addressing, serving a single object.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/apt-repo-bucket/dists/stable/Release" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", `"release-etag"`)
+		http.ServeContent(w, r, "Release", lastModified, bytes.NewReader(content))
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	out := &bytes.Buffer{}
+	method := New(log.New(out, "", 0))
+	cfg := newConfig(configTree(t,
+		"Acquire::s3::endpoint::"+host+"="+server.URL,
+		"Acquire::s3::force-path-style=true",
+	))
+
+	dir, err := ioutil.TempDir("", "acquire")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "Release")
+	uri := "s3://access-key:secret-key@" + host + "/apt-repo-bucket/dists/stable/Release"
+	method.wg.Add(1)
+	if err := method.acquire(cfg, message.URIAcquire{URI: uri, Filename: filename}, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if actual, err := ioutil.ReadFile(filename); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !bytes.Equal(actual, content) {
+		t.Errorf("downloaded %q; expected %q", actual, content)
+	}
+	sha256Sum := sha256.Sum256(content)
+	for _, expected := range []string{
+		"102 Status\nURI: " + uri + "\nMessage: Connecting to " + host + "\n",
+		"201 URI Done\nURI: " + uri + "\n",
+		"SHA256-Hash: " + hex.EncodeToString(sha256Sum[:]) + "\n",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("output = %s; expected it to contain %q", out.String(), expected)
+		}
+	}
+
+	out.Reset()
+	missing := "s3://access-key:secret-key@" + host + "/apt-repo-bucket/dists/stable/InRelease"
+	method.wg.Add(1)
+	if err := method.acquire(cfg, message.URIAcquire{URI: missing, Filename: filename + ".missing"}, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "400 URI Failure\nURI: " + missing + "\n"; !strings.Contains(out.String(), expected) {
+		t.Errorf("output = %s; expected it to contain %q", out.String(), expected)
+	}
+}
+
+func TestURIStartInQueueOrder(t *testing.T) {
+	// The HEAD request for the first object is held back until the second
+	// object has been requested, so the second object is always ready first.
+	secondRequested := make(chan struct{})
+	var once sync.Once
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/apt-repo-bucket/first":
+			select {
+			case <-secondRequested:
+			case <-time.After(5 * time.Second):
+			}
+		case "/apt-repo-bucket/second":
+			once.Do(func() { close(secondRequested) })
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		lastModified := time.Date(2018, time.October, 25, 20, 17, 39, 0, time.UTC)
+		http.ServeContent(w, r, "", lastModified, strings.NewReader(r.URL.Path))
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	dir, err := ioutil.TempDir("", "acquire")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := &bytes.Buffer{}
+	method := New(log.New(out, "", 0))
+	method.publishConfig(newConfig(configTree(t,
+		"Acquire::s3::endpoint::"+host+"="+server.URL,
+		"Acquire::s3::force-path-style=true",
+		"Acquire::s3::Max-Parallel=2",
+	)))
+
+	var input strings.Builder
+	var uris []string
+	for _, key := range []string{"first", "second"} {
+		uri := "s3://access-key:secret-key@" + host + "/apt-repo-bucket/" + key
+		uris = append(uris, uri)
+		fmt.Fprintf(&input, "600 URI Acquire\nURI: %s\nFilename: %s\n\n", uri, filepath.Join(dir, key))
+	}
+	go method.readInput(strings.NewReader(input.String()))
+	go method.processMessages()
+	method.wg.Wait()
+
+	first := strings.Index(out.String(), "200 URI Start\nURI: "+uris[0]+"\n")
+	second := strings.Index(out.String(), "200 URI Start\nURI: "+uris[1]+"\n")
+	if first < 0 || second < 0 || first > second {
+		t.Errorf("output = %s; expected a URI Start for %s followed by one for %s", out.String(), uris[0], uris[1])
+	}
+}
+
+func TestIMSHit(t *testing.T) {
+	uri := "s3://s3.amazonaws.com/apt-repo-bucket/apt/dists/trusty/main/binary-amd64/Packages"
+	lastModified := time.Date(2018, time.October, 25, 20, 17, 39, 0, time.UTC)
+
+	msg, err := message.Marshal(imsHit(uri, "/tmp/Packages", lastModified))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	actual := msg.String()
+	expected := `201 URI Done
+URI: s3://s3.amazonaws.com/apt-repo-bucket/apt/dists/trusty/main/binary-amd64/Packages
+Filename: /tmp/Packages
+Last-Modified: Thu, 25 Oct 2018 20:17:39 GMT
+IMS-Hit: true
+`
+	if actual != expected {
+		t.Errorf("imsHit() = %s; expected %s", actual, expected)
+	}
+}
+
+func TestAcquireIMSHit(t *testing.T) {
+	lastModified := time.Date(2018, time.October, 25, 20, 17, 39, 0, time.UTC)
+	host, cfg, cleanup := fakeS3(t, "/apt-repo-bucket/Packages", []byte("new content\n"), lastModified)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "acquire")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "Packages")
+	if err := ioutil.WriteFile(filename, []byte("old content\n"), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	out := &bytes.Buffer{}
+	method := New(log.New(out, "", 0))
+	uri := "s3://access-key:secret-key@" + host + "/apt-repo-bucket/Packages"
+	method.wg.Add(1)
+	req := message.URIAcquire{URI: uri, Filename: filename, LastModified: lastModified}
+	if err := method.acquire(cfg, req, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "201 URI Done\nURI: " + uri + "\nFilename: " + filename +
+		"\nLast-Modified: Thu, 25 Oct 2018 20:17:39 GMT\nIMS-Hit: true\n"
+	if !strings.Contains(out.String(), expected) {
+		t.Errorf("output = %s; expected it to contain %q", out.String(), expected)
+	}
+	if actual, err := ioutil.ReadFile(filename); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if string(actual) != "old content\n" {
+		t.Errorf("file content = %q; expected the file to be left alone", actual)
+	}
+}
+
+func TestAcquireHashSumMismatch(t *testing.T) {
+	lastModified := time.Date(2018, time.October, 25, 20, 17, 39, 0, time.UTC)
+	host, cfg, cleanup := fakeS3(t, "/apt-repo-bucket/Release", []byte("Suite: stable\n"), lastModified)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "acquire")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "Release")
+
+	out := &bytes.Buffer{}
+	method := New(log.New(out, "", 0))
+	method.publishConfig(cfg)
+	uri := "s3://access-key:secret-key@" + host + "/apt-repo-bucket/Release"
+	msg, err := message.Marshal(message.URIAcquire{URI: uri, Filename: filename, ExpectedSHA256: strings.Repeat("0", 64)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	method.wg.Add(1)
+	method.uriAcquire(msg, nil)
+
+	for _, expected := range []string{"400 URI Failure\nURI: " + uri + "\n", "FailReason: HashSumMismatch\n"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("output = %s; expected it to contain %q", out.String(), expected)
+		}
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(%s) = %v; expected the file to be removed", filename, err)
+	}
+}
+
+func TestOpenPartial(t *testing.T) {
+	lastModified := time.Date(2018, time.October, 25, 20, 17, 39, 0, time.UTC)
+	specs := map[string]struct {
+		content     string
+		modTime     time.Time
+		expectedLen int64
+		expected    int64
+	}{
+		"no partial file":        {"", lastModified, 10, 0},
+		"valid partial file":     {"hello", lastModified.Add(time.Hour), 10, 5},
+		"object modified since":  {"hello", lastModified.Add(-time.Hour), 10, 0},
+		"partial file too large": {"hello world", lastModified.Add(time.Hour), 10, 0},
+	}
+
+	for name, spec := range specs {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "partial")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer os.RemoveAll(dir)
+			filename := filepath.Join(dir, "partial")
+			if spec.content != "" {
+				if err := ioutil.WriteFile(filename, []byte(spec.content), 0o600); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if err := os.Chtimes(filename, spec.modTime, spec.modTime); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			file, resumePoint, err := openPartial(filename, spec.expectedLen, lastModified)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer file.Close()
+			if resumePoint != spec.expected {
+				t.Errorf("resumePoint = %d; expected %d", resumePoint, spec.expected)
+			}
+			info, err := file.Stat()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if info.Size() != resumePoint {
+				t.Errorf("file size = %d; expected %d", info.Size(), resumePoint)
+			}
+		})
+	}
+}
+
+func TestURIFailure(t *testing.T) {
+	uri := "s3://s3.amazonaws.com/apt-repo-bucket/apt/generic/python-bernhard_0.2.3-1_all.deb"
+	err := awserr.NewRequestFailure(awserr.New("Forbidden", "Forbidden", nil), 403, "fake-request-id")
+	failure := uriFailure(uri, err)
+
+	if failure.URI != uri {
+		t.Errorf("URI = %s; expected %s", failure.URI, uri)
+	}
+	if strings.Contains(failure.Message, "\n") {
+		t.Errorf("Message = %q; expected no newlines", failure.Message)
+	}
+	if failure.FailReason != "HttpError403" {
+		t.Errorf("FailReason = %s; expected %s", failure.FailReason, "HttpError403")
+	}
+}
+
+func TestFailReason(t *testing.T) {
+	specs := map[string]struct {
+		err      error
+		expected string
+	}{
+		"request failure": {
+			awserr.NewRequestFailure(awserr.New("InternalError", "We encountered an internal error.", nil), 500, "id"),
+			"HttpError500",
+		},
+		"wrapped request failure": {
+			fmt.Errorf("downloading: %w", awserr.NewRequestFailure(awserr.New("SlowDown", "Slow down", nil), 503, "id")),
+			"HttpError503",
+		},
+		"resolve failure": {
+			awserr.New("RequestError", "send request failed", &net.DNSError{Err: "no such host", Name: "bucket"}),
+			"ResolveFailure",
+		},
+		"connection refused": {
+			awserr.New("RequestError", "send request failed", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}),
+			"ConnectionRefused",
+		},
+		"unknown": {
+			errors.New("something went wrong"),
+			"",
+		},
+	}
+
+	for name, spec := range specs {
+		t.Run(name, func(t *testing.T) {
+			if actual := failReason(spec.err); actual != spec.expected {
+				t.Errorf("failReason(%v) = %q; expected %q", spec.err, actual, spec.expected)
+			}
+		})
+	}
+}
+
+// fakeS3 starts a minimal stand-in for an S3 compatible server with path-style
+// addressing, serving a single object at path. It returns the server's host,
+// the config to reach it with and a function that stops the server.
+func fakeS3(t *testing.T, path string, content []byte, lastModified time.Time) (string, *config, func()) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "", lastModified, bytes.NewReader(content))
+	}))
+	host := strings.TrimPrefix(server.URL, "http://")
+	cfg := newConfig(configTree(t,
+		"Acquire::s3::endpoint::"+host+"="+server.URL,
+		"Acquire::s3::force-path-style=true",
+	))
+	return host, cfg, server.Close
+}
+
 func logger(t *testing.T) *log.Logger {
 	t.Helper()
 	return log.New(os.Stdout, "", 0)
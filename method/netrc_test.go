@@ -0,0 +1,125 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package method
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const (
+	authConf = `# S3 credentials
+machine s3.amazonaws.com/other-bucket login other-key password other-secret
+machine s3.amazonaws.com
+login fake-access-key-id
+password fake-access-key-secret
+machine https://example.com login user password pass
+`
+	authConfPart = `machine s3.amazonaws.com/apt-repo-bucket login part-key password part-secret
+machine minio.internal login minio-key password minio-secret
+`
+)
+
+func TestParseNetrc(t *testing.T) {
+	entries, err := parseNetrc(strings.NewReader(authConf))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []netrcEntry{
+		{host: "s3.amazonaws.com", path: "/other-bucket", login: "other-key", password: "other-secret"},
+		{host: "s3.amazonaws.com", login: "fake-access-key-id", password: "fake-access-key-secret"},
+		{scheme: "https", host: "example.com", login: "user", password: "pass"},
+	}
+	if diff := cmp.Diff(expected, entries, cmp.AllowUnexported(netrcEntry{})); diff != "" {
+		t.Errorf("parseNetrc() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestNetrcCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netrc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "auth.conf.d"), 0o700); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	files := map[string]string{
+		"auth.conf":                 authConf,
+		"auth.conf.d/90-repo.conf":  authConfPart,
+		"auth.conf.d/README.ignore": "machine s3.amazonaws.com login ignored password ignored",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	cfg := defaultConfig()
+	cfg.dirEtc = dir
+
+	specs := map[string]struct {
+		uri           string
+		expectedFound bool
+		expectedLogin string
+	}{
+		"auth.conf takes precedence": {"s3://s3.amazonaws.com/apt-repo-bucket/Packages", true, "fake-access-key-id"},
+		"auth.conf.d":                {"s3://minio.internal/bucket/Packages", true, "minio-key"},
+		"path prefix":                {"s3://s3.amazonaws.com/other-bucket/Packages", true, "other-key"},
+		"host only":                  {"s3://s3.amazonaws.com/another-bucket/Packages", true, "fake-access-key-id"},
+		"scheme mismatch":            {"s3://example.com/bucket/Packages", false, ""},
+		"no match":                   {"s3://s3.us-west-2.amazonaws.com/bucket/Packages", false, ""},
+	}
+
+	for name, spec := range specs {
+		t.Run(name, func(t *testing.T) {
+			uri, err := url.Parse(spec.uri)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			user, found, err := netrcCredentials(cfg, uri)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if found != spec.expectedFound {
+				t.Fatalf("netrcCredentials(%s) found = %t; expected %t", spec.uri, found, spec.expectedFound)
+			}
+			if found && user.Username() != spec.expectedLogin {
+				t.Errorf("netrcCredentials(%s) login = %s; expected %s", spec.uri, user.Username(), spec.expectedLogin)
+			}
+		})
+	}
+}
+
+func TestAPTPath(t *testing.T) {
+	specs := []struct {
+		parts    []string
+		expected string
+	}{
+		{[]string{"/", "etc/apt/", "auth.conf"}, "/etc/apt/auth.conf"},
+		{[]string{"/chroot", "etc/apt/", "auth.conf"}, "/chroot/etc/apt/auth.conf"},
+		{[]string{"/", "etc/apt/", "/srv/auth.conf"}, "/srv/auth.conf"},
+	}
+	for _, spec := range specs {
+		if actual := aptPath(spec.parts...); actual != spec.expected {
+			t.Errorf("aptPath(%q) = %s; expected %s", spec.parts, actual, spec.expected)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package method
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	progressInterval = 1 * time.Second
+	percent          = 100
+)
+
+// A progressWriter is an io.WriterAt that keeps track of the number of bytes
+// written through it and periodically reports them, so that APT has something
+// to show while large objects are downloaded.
+type progressWriter struct {
+	w        io.WriterAt
+	total    int64
+	interval time.Duration
+	report   func(status string)
+
+	mu       sync.Mutex
+	received int64
+	last     time.Time
+}
+
+// newProgressWriter returns a progressWriter that writes to w and calls report
+// at most once per interval. The download is expected to have total bytes, of
+// which received were already available before it started, e.g. because it
+// is being resumed.
+func newProgressWriter(w io.WriterAt, total, received int64, interval time.Duration,
+	report func(status string)) *progressWriter {
+
+	return &progressWriter{
+		w:        w,
+		total:    total,
+		received: received,
+		interval: interval,
+		report:   report,
+		last:     time.Now(),
+	}
+}
+
+// WriteAt writes p to the underlying io.WriterAt and reports progress if the
+// interval has elapsed since the last report.
+func (pw *progressWriter) WriteAt(p []byte, off int64) (int, error) {
+	n, err := pw.w.WriteAt(p, off)
+
+	pw.mu.Lock()
+	pw.received += int64(n)
+	now := time.Now()
+	var status string
+	if now.Sub(pw.last) >= pw.interval {
+		pw.last = now
+		status = progressStatus(pw.received, pw.total)
+	}
+	pw.mu.Unlock()
+
+	// Reporting happens outside the lock so that a slow writer does not hold
+	// up the other parts of the download.
+	if status != "" {
+		pw.report(status)
+	}
+	return n, err
+}
+
+// progressStatus formats the number of bytes received so far, e.g.
+// "Received 52428800 of 209715200 bytes (25%)".
+func progressStatus(received, total int64) string {
+	if total <= 0 {
+		return fmt.Sprintf("Received %d bytes", received)
+	}
+	return fmt.Sprintf("Received %d of %d bytes (%d%%)", received, total, received*percent/total)
+}
@@ -0,0 +1,71 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package method
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type discardWriterAt struct{}
+
+func (discardWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	return len(p), nil
+}
+
+func TestProgressWriter(t *testing.T) {
+	var statuses []string
+	pw := newProgressWriter(discardWriterAt{}, 200, 50, 0, func(status string) {
+		statuses = append(statuses, status)
+	})
+	for _, off := range []int64{0, 50, 100} {
+		if _, err := pw.WriteAt(make([]byte, 50), off); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	expected := []string{
+		"Received 100 of 200 bytes (50%)",
+		"Received 150 of 200 bytes (75%)",
+		"Received 200 of 200 bytes (100%)",
+	}
+	if diff := cmp.Diff(expected, statuses); diff != "" {
+		t.Errorf("progress statuses mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestProgressWriterThrottles(t *testing.T) {
+	reports := 0
+	pw := newProgressWriter(discardWriterAt{}, 200, 0, time.Hour, func(string) {
+		reports++
+	})
+	for i := 0; i < 4; i++ {
+		if _, err := pw.WriteAt(make([]byte, 50), int64(i*50)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if reports != 0 {
+		t.Errorf("Found %d reports; expected %d", reports, 0)
+	}
+}
+
+func TestProgressStatusUnknownTotal(t *testing.T) {
+	actual := progressStatus(1024, 0)
+	expected := "Received 1024 bytes"
+	if actual != expected {
+		t.Errorf("progressStatus(1024, 0) = %s; expected %s", actual, expected)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package method
+
+import (
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// redacted replaces secrets removed from text shown to the user.
+const redacted = "REDACTED"
+
+var (
+	// urlUserinfoPattern matches the scheme and userinfo of a URL. The password
+	// may contain unescaped slashes, see preProcessURL, but the user name
+	// cannot. An @ in the path, as in s3://bucket/path@v1 or
+	// s3://host:9000/path@v1, is therefore not taken for the end of a
+	// userinfo: there, the first slash comes before any colon or right after a
+	// port number.
+	urlUserinfoPattern = regexp.MustCompile(
+		`([A-Za-z][A-Za-z0-9+.-]*://)[^\s/@:]*(?::(?:[^\s/@]*|[^\s/@]*[^\s/@0-9][^\s/@]*/[^\s@]*))?@`)
+	// signedQueryPattern matches the query parameters of presigned S3 URLs that
+	// carry credentials or signatures.
+	signedQueryPattern = regexp.MustCompile(
+		`(?i)\b(X-Amz-Signature|X-Amz-Credential|X-Amz-Security-Token|Signature|AWSAccessKeyId)=[^&\s]*`)
+)
+
+// redact removes credentials from text produced by the Method before it is
+// shown to the user: any of the given secrets, the userinfo of URLs and the
+// values of query parameters used to sign requests.
+//
+// Only free text such as the Message field is redacted. URI fields echo the
+// URI exactly as APT sent it, since APT uses them to match responses to its
+// requests.
+func redact(text string, secrets ...string) string {
+	// Replace longer secrets first, so that a secret containing another is
+	// not left partially visible.
+	sorted := append([]string(nil), secrets...)
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	for _, secret := range sorted {
+		if secret != "" {
+			text = strings.ReplaceAll(text, secret, redacted)
+		}
+	}
+	text = urlUserinfoPattern.ReplaceAllString(text, "${1}")
+	return signedQueryPattern.ReplaceAllString(text, "${1}="+redacted)
+}
+
+// uriSecrets returns the access key id and secret access key embedded in a URI
+// sent by APT, both as sent and with slashes escaped, so that they can be
+// redacted wherever they appear.
+func uriSecrets(uri string) []string {
+	parsed, err := url.Parse(preProcessURL(uri))
+	if err != nil || parsed.User == nil {
+		return nil
+	}
+	secrets := []string{parsed.User.Username()}
+	if password, ok := parsed.User.Password(); ok {
+		secrets = append(secrets, password)
+	}
+	for _, secret := range secrets {
+		if escaped := strings.ReplaceAll(secret, "/", "%2F"); escaped != secret {
+			secrets = append(secrets, escaped)
+		}
+	}
+	return secrets
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package method
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	specs := map[string]struct {
+		text     string
+		expected string
+	}{
+		"userinfo": {
+			"Get s3://fake-access-key-id:fake-ac/cess-key-secret@s3.amazonaws.com/bucket/key: denied",
+			"Get s3://s3.amazonaws.com/bucket/key: denied",
+		},
+		"userinfo without slashes": {
+			"Get s3://fake-access-key-id:fake-access-key-secret@s3.amazonaws.com/bucket/key: denied",
+			"Get s3://s3.amazonaws.com/bucket/key: denied",
+		},
+		"at sign in path": {
+			"Get s3://bucket/path@v1: denied",
+			"Get s3://bucket/path@v1: denied",
+		},
+		"at sign in path after port": {
+			"Get s3://minio.internal:9000/bucket/path@v1: denied",
+			"Get s3://minio.internal:9000/bucket/path@v1: denied",
+		},
+		"signed query": {
+			"Get https://bucket.s3.amazonaws.com/key?X-Amz-Credential=AKIA%2F20240101&X-Amz-Signature=abc123&versionId=1",
+			"Get https://bucket.s3.amazonaws.com/key?X-Amz-Credential=REDACTED&X-Amz-Signature=REDACTED&versionId=1",
+		},
+		"no credentials": {
+			"Connecting to s3.us-west-2.amazonaws.com",
+			"Connecting to s3.us-west-2.amazonaws.com",
+		},
+	}
+
+	for name, spec := range specs {
+		t.Run(name, func(t *testing.T) {
+			if actual := redact(spec.text); actual != spec.expected {
+				t.Errorf("redact(%q) = %q; expected %q", spec.text, actual, spec.expected)
+			}
+		})
+	}
+}
+
+func TestURIFailureRedactsSecrets(t *testing.T) {
+	uri := "s3://fake-access-key-id:fake-ac/cess-key-secret@s3.amazonaws.com/apt-repo-bucket/apt/generic/python-bernhard_0.2.3-1_all.deb"
+	err := errors.New("SignatureDoesNotMatch: secret fake-ac%2Fcess-key-secret for fake-access-key-id was rejected")
+	failure := uriFailure(uri, err)
+
+	if failure.URI != uri {
+		t.Errorf("URI = %s; expected the URI exactly as sent by APT: %s", failure.URI, uri)
+	}
+	actual := failure.Message
+	for _, secret := range []string{"fake-access-key-id", "fake-ac/cess-key-secret", "fake-ac%2Fcess-key-secret"} {
+		if strings.Contains(actual, secret) {
+			t.Errorf("Message = %q; expected %q to be redacted", actual, secret)
+		}
+	}
+}
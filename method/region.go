@@ -0,0 +1,124 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package method
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// logDiscoveredRegion is logged when a bucket turns out to be in a region
+// other than the configured one.
+const logDiscoveredRegion = "Discovered region %s for bucket %s, set Acquire::s3::Bucket::%s::region to avoid the lookup"
+
+// A regionCache remembers the regions of buckets that were discovered while
+// acquiring URIs, so that each bucket's region is only looked up once for the
+// lifetime of the Method.
+type regionCache struct {
+	lookup func(ctx context.Context, client s3iface.S3API, bucket string) (string, error)
+
+	mu      sync.Mutex
+	regions map[string]string
+}
+
+func newRegionCache(lookup func(ctx context.Context, client s3iface.S3API, bucket string) (string, error)) *regionCache {
+	return &regionCache{
+		lookup:  lookup,
+		regions: map[string]string{},
+	}
+}
+
+// lookupBucketRegion asks S3 for the region of the bucket. This works from any
+// region, and regardless of whether the client may access the bucket.
+func lookupBucketRegion(ctx context.Context, client s3iface.S3API, bucket string) (string, error) {
+	return s3manager.GetBucketRegionWithClient(ctx, client, bucket)
+}
+
+// get returns the discovered region of the bucket, if any.
+func (cache *regionCache) get(bucket string) (string, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	region, ok := cache.regions[bucket]
+	return region, ok
+}
+
+// discover returns the region of the bucket, looking it up with the given
+// client unless it is already known. Unlike clientCache, the lock is not held
+// during the lookup, since it makes a request.
+func (cache *regionCache) discover(ctx context.Context, client s3iface.S3API, bucket string) (string, error) {
+	if region, ok := cache.get(bucket); ok {
+		return region, nil
+	}
+	region, err := cache.lookup(ctx, client, bucket)
+	if err != nil {
+		return "", fmt.Errorf("looking up region of bucket %s: %w", bucket, err)
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.regions[bucket] = region
+	return region, nil
+}
+
+// forBucket returns cfg with the discovered region of the bucket applied.
+// Buckets behind a custom endpoint are left alone.
+func (cache *regionCache) forBucket(cfg *config, bucket string) *config {
+	region, ok := cache.get(bucket)
+	if !ok || cfg.endpoint != "" || region == cfg.region {
+		return cfg
+	}
+	regional := *cfg
+	regional.region = region
+	return &regional
+}
+
+// isRegionError reports whether err is the response of S3 to a request that
+// was sent to the endpoint of a region other than the bucket's. Responses to
+// HEAD requests have no body, so only the status code can be relied upon.
+func isRegionError(err error) bool {
+	var reqErr awserr.RequestFailure
+	if !errors.As(err, &reqErr) {
+		return false
+	}
+	switch reqErr.StatusCode() {
+	case http.StatusMovedPermanently, http.StatusBadRequest:
+		return true
+	default:
+		return false
+	}
+}
+
+// discoverRegion looks up the region of the bucket if err shows that a request
+// for it was sent to the wrong regional endpoint. It reports whether the
+// bucket is in a region other than cfg's, in which case the request should be
+// retried against the bucket's own region.
+func (method *Method) discoverRegion(cfg *config, client s3iface.S3API, bucket string, err error) bool {
+	if cfg.endpoint != "" || !isRegionError(err) {
+		return false
+	}
+	region, lookupErr := method.regions.discover(method.ctx, client, bucket)
+	if lookupErr != nil || region == cfg.region {
+		return false
+	}
+	method.outputGeneralLog(fmt.Sprintf(logDiscoveredRegion, region, bucket, bucket))
+	return true
+}
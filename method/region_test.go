@@ -0,0 +1,122 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package method
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+func TestRegionCache(t *testing.T) {
+	lookups := map[string]int{}
+	cache := newRegionCache(func(ctx context.Context, client s3iface.S3API, bucket string) (string, error) {
+		lookups[bucket]++
+		if bucket == "missing-bucket" {
+			return "", errors.New("NotFound")
+		}
+		return "eu-west-1", nil
+	})
+
+	for i := 0; i < 3; i++ {
+		region, err := cache.discover(context.Background(), nil, "eu-bucket")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if region != "eu-west-1" {
+			t.Errorf("discover() = %s; expected eu-west-1", region)
+		}
+	}
+	if lookups["eu-bucket"] != 1 {
+		t.Errorf("looked up eu-bucket %d times; expected 1", lookups["eu-bucket"])
+	}
+
+	if _, err := cache.discover(context.Background(), nil, "missing-bucket"); err == nil {
+		t.Error("expected an error for a failed lookup")
+	}
+	if _, ok := cache.get("missing-bucket"); ok {
+		t.Error("expected a failed lookup not to be cached")
+	}
+
+	cfg := &config{region: "us-east-1"}
+	if actual := cache.forBucket(cfg, "eu-bucket").region; actual != "eu-west-1" {
+		t.Errorf("forBucket(eu-bucket).region = %s; expected eu-west-1", actual)
+	}
+	if actual := cache.forBucket(cfg, "other-bucket").region; actual != "us-east-1" {
+		t.Errorf("forBucket(other-bucket).region = %s; expected us-east-1", actual)
+	}
+	custom := &config{region: "us-east-1", endpoint: "https://minio.internal:9000"}
+	if actual := cache.forBucket(custom, "eu-bucket").region; actual != "us-east-1" {
+		t.Errorf("forBucket(eu-bucket).region = %s; expected custom endpoints to keep their region", actual)
+	}
+}
+
+func TestIsRegionError(t *testing.T) {
+	specs := map[string]struct {
+		err      error
+		expected bool
+	}{
+		"moved permanently": {
+			awserr.NewRequestFailure(awserr.New("MovedPermanently", "", nil), 301, "id"),
+			true,
+		},
+		"wrapped bad request": {
+			fmt.Errorf("heading object: %w", awserr.NewRequestFailure(awserr.New("BadRequest", "", nil), 400, "id")),
+			true,
+		},
+		"forbidden": {
+			awserr.NewRequestFailure(awserr.New("Forbidden", "", nil), 403, "id"),
+			false,
+		},
+		"other error": {
+			errors.New("connection reset by peer"),
+			false,
+		},
+	}
+
+	for name, spec := range specs {
+		t.Run(name, func(t *testing.T) {
+			if actual := isRegionError(spec.err); actual != spec.expected {
+				t.Errorf("isRegionError(%v) = %t; expected %t", spec.err, actual, spec.expected)
+			}
+		})
+	}
+}
+
+func TestDiscoverRegion(t *testing.T) {
+	out := &bytes.Buffer{}
+	method := New(log.New(out, "", 0))
+	method.regions = newRegionCache(func(ctx context.Context, client s3iface.S3API, bucket string) (string, error) {
+		return "eu-west-1", nil
+	})
+	redirect := awserr.NewRequestFailure(awserr.New("MovedPermanently", "", nil), 301, "id")
+
+	if method.discoverRegion(&config{region: "eu-west-1"}, nil, "eu-bucket", redirect) {
+		t.Error("discoverRegion() = true; expected false for a bucket in the configured region")
+	}
+	if !method.discoverRegion(&config{region: "us-east-1"}, nil, "eu-bucket", redirect) {
+		t.Error("discoverRegion() = false; expected true for a bucket in another region")
+	}
+	expected := "101 Log\nMessage: Discovered region eu-west-1 for bucket eu-bucket"
+	if !strings.HasPrefix(out.String(), expected) {
+		t.Errorf("output = %q; expected it to start with %q", out.String(), expected)
+	}
+}
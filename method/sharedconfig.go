@@ -0,0 +1,125 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package method
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+const (
+	// defaultProfile is the profile the SDK uses if no other one is selected.
+	defaultProfile = "default"
+	// ssoKeyPrefix starts the keys of profiles that use SSO cached tokens,
+	// e.g. sso_start_url.
+	ssoKeyPrefix = "sso_"
+)
+
+// checkSSOProfile fails if the profile selected by opts uses SSO cached
+// tokens. The SSO credential provider was added in aws-sdk-go v1.37.0; with the
+// SDK this module depends on, such profiles silently fall back to the
+// environment and instance role credentials, so S3 would be accessed as the
+// wrong principal.
+func checkSSOProfile(opts session.Options) error {
+	if !sharedConfigEnabled(opts) {
+		return nil
+	}
+	profile := selectedProfile(opts)
+	for _, filename := range sharedConfigFiles(opts) {
+		usesSSO, err := profileUsesSSO(filename, profile)
+		if err != nil {
+			return err
+		}
+		if usesSSO {
+			return fmt.Errorf("profile %s in %s: %w", profile, filename, errSSOProfile)
+		}
+	}
+	return nil
+}
+
+// sharedConfigEnabled reports whether the SDK loads the shared config file,
+// which is where profiles using SSO are configured.
+func sharedConfigEnabled(opts session.Options) bool {
+	switch opts.SharedConfigState {
+	case session.SharedConfigEnable:
+		return true
+	case session.SharedConfigDisable:
+		return false
+	case session.SharedConfigStateFromEnv:
+	}
+	enabled, _ := strconv.ParseBool(os.Getenv("AWS_SDK_LOAD_CONFIG"))
+	return enabled
+}
+
+// selectedProfile returns the name of the profile the SDK loads for opts, with
+// the shared config enabled.
+func selectedProfile(opts session.Options) string {
+	for _, profile := range []string{opts.Profile, os.Getenv("AWS_PROFILE"), os.Getenv("AWS_DEFAULT_PROFILE")} {
+		if profile != "" {
+			return profile
+		}
+	}
+	return defaultProfile
+}
+
+// sharedConfigFiles returns the files the SDK loads profiles from for opts,
+// with the shared config enabled.
+func sharedConfigFiles(opts session.Options) []string {
+	if opts.SharedConfigFiles != nil {
+		return opts.SharedConfigFiles
+	}
+	configFile, credsFile := os.Getenv("AWS_CONFIG_FILE"), os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if configFile == "" {
+		configFile = defaults.SharedConfigFilename()
+	}
+	if credsFile == "" {
+		credsFile = defaults.SharedCredentialsFilename()
+	}
+	return []string{configFile, credsFile}
+}
+
+// profileUsesSSO reports whether the named profile in the given shared config
+// file has any SSO settings. A missing file has no profiles.
+func profileUsesSSO(filename, profile string) (bool, error) {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	inProfile := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			// The config file names profiles "profile <name>", except for the
+			// default profile; the credentials file names them "<name>".
+			section := strings.TrimSpace(line[1 : len(line)-1])
+			inProfile = strings.TrimSpace(strings.TrimPrefix(section, "profile ")) == profile
+			continue
+		}
+		if inProfile && strings.HasPrefix(strings.ToLower(line), ssoKeyPrefix) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package method
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+const sharedConfig = `[default]
+region = us-east-1
+
+[profile sso]
+sso_start_url = https://example.awsapps.com/start
+sso_account_id = 123456789012
+sso_role_name = apt
+
+[profile keys]
+credential_process = /usr/local/bin/apt-credentials
+`
+
+func TestCheckSSOProfile(t *testing.T) {
+	file, cleanup := tempFile(t)
+	defer cleanup()
+	if _, err := file.WriteString(sharedConfig); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	missing := filepath.Join(filepath.Dir(file.Name()), "missing-credentials")
+
+	specs := map[string]struct {
+		opts        session.Options
+		expectedErr error
+	}{
+		"sso profile": {
+			session.Options{Profile: "sso", SharedConfigState: session.SharedConfigEnable},
+			errSSOProfile,
+		},
+		"other profile": {
+			session.Options{Profile: "keys", SharedConfigState: session.SharedConfigEnable},
+			nil,
+		},
+		"default profile": {
+			session.Options{SharedConfigState: session.SharedConfigEnable},
+			nil,
+		},
+		"shared config disabled": {
+			session.Options{Profile: "sso", SharedConfigState: session.SharedConfigDisable},
+			nil,
+		},
+	}
+
+	key := clientKey{region: "us-east-1", profile: "sso", sharedConfigFile: file.Name()}
+	if _, err := newS3Client(key); !errors.Is(err, errSSOProfile) {
+		t.Errorf("newS3Client() = %v; expected %v", err, errSSOProfile)
+	}
+
+	for name, spec := range specs {
+		t.Run(name, func(t *testing.T) {
+			spec.opts.SharedConfigFiles = []string{missing, file.Name()}
+			if err := checkSSOProfile(spec.opts); !errors.Is(err, spec.expectedErr) {
+				t.Errorf("checkSSOProfile() = %v; expected %v", err, spec.expectedErr)
+			}
+		})
+	}
+}
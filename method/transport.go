@@ -0,0 +1,239 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package method
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+
+	"github.com/google/apt-golang-s3/aptconfig"
+)
+
+// proxyDirect is the proxy setting that disables the use of a proxy.
+const proxyDirect = "DIRECT"
+
+// A proxySettings looks up the proxy to use for requests to S3 endpoints, e.g.
+//
+// Acquire::s3::Proxy "http://proxy.internal:3128";
+// Acquire::https::Proxy::s3.eu-west-1.amazonaws.com "DIRECT";
+type proxySettings struct {
+	tree *aptconfig.Config
+}
+
+// proxyFor returns the proxy setting for requests to host over scheme, and
+// reports whether there is one. Settings for the host take precedence over the
+// global ones, and S3 settings take precedence over those of the scheme. Like
+// APT's https method, requests over https fall back to the http settings:
+//
+// Acquire::s3::Proxy::<host>
+// Acquire::http(s)::Proxy::<host>
+// Acquire::s3::Proxy
+// Acquire::http(s)::Proxy
+// Acquire::http::Proxy::<host>
+// Acquire::http::Proxy
+func (proxies proxySettings) proxyFor(scheme, host string) (string, bool) {
+	s3Proxy := configName(configItemAcquireS3, configItemProxy)
+	schemeProxy := configName(configItemAcquire, scheme, configItemProxy)
+	names := []string{configName(s3Proxy, host), configName(schemeProxy, host), s3Proxy, schemeProxy}
+	if scheme == "https" {
+		httpProxy := configName(configItemAcquire, "http", configItemProxy)
+		names = append(names, configName(httpProxy, host), httpProxy)
+	}
+	return proxies.tree.Lookup(names...)
+}
+
+// forEndpoint returns the proxy function for the transport of clients of the
+// S3 endpoint at endpointHost. The SDK addresses buckets as subdomains of the
+// endpoint host, e.g. my-bucket.s3.eu-west-1.amazonaws.com, so settings for
+// the endpoint host apply to those requests too. Other requests, such as
+// those to STS, use the settings for their own host.
+func (proxies proxySettings) forEndpoint(endpointHost string) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		host := req.URL.Hostname()
+		if endpointHost != "" && strings.HasSuffix(host, "."+endpointHost) {
+			host = endpointHost
+		}
+		return proxies.proxy(req, host)
+	}
+}
+
+// proxy returns the proxy to use for the request, looking up host-scoped
+// settings for the given host, or nil if it should be sent directly. If APT
+// has no proxy setting for the request, the proxy is taken from the
+// environment. Proxy credentials may be given in the proxy URL.
+func (proxies proxySettings) proxy(req *http.Request, host string) (*url.URL, error) {
+	value, ok := proxies.proxyFor(req.URL.Scheme, host)
+	if !ok {
+		return http.ProxyFromEnvironment(req)
+	}
+	if value == "" || strings.EqualFold(value, proxyDirect) {
+		//nolint:nilnil // A nil URL tells http.Transport not to use a proxy.
+		return nil, nil
+	}
+	if !strings.Contains(value, "://") {
+		value = "http://" + value
+	}
+	proxyURL, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("parsing proxy for %s: %w", req.URL.Host, err)
+	}
+	return proxyURL, nil
+}
+
+// tlsSettings locate the files that configure TLS connections to an S3
+// endpoint, e.g.
+//
+// Acquire::s3::CaInfo "/etc/ssl/certs/corp-ca.pem";
+// Acquire::s3::minio.internal::SslCert "/etc/apt/minio-client.pem";
+// Acquire::s3::minio.internal::SslKey "/etc/apt/minio-client.key";
+//
+// Like in APT, a CA bundle replaces the system's trusted roots.
+type tlsSettings struct {
+	caInfo, sslCert, sslKey string
+}
+
+// A transportKey identifies the transports that can be shared by S3 clients.
+type transportKey struct {
+	endpointHost string
+	tls          tlsSettings
+}
+
+// A transportCache builds the http.Transports used by S3 clients on first use,
+// one for each endpoint host and distinct set of TLS settings, so that clients
+// with the same settings share connections.
+type transportCache struct {
+	proxies proxySettings
+
+	mu         sync.Mutex
+	transports map[transportKey]*http.Transport
+}
+
+func newTransportCache(proxies proxySettings) *transportCache {
+	return &transportCache{
+		proxies:    proxies,
+		transports: map[transportKey]*http.Transport{},
+	}
+}
+
+// get returns the transport for the S3 endpoint at endpointHost with the given
+// TLS settings, building it if necessary. Settings whose files cannot be
+// loaded are not cached, so that fixing them doesn't require a restart.
+func (cache *transportCache) get(endpointHost string, settings tlsSettings) (*http.Transport, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	key := transportKey{endpointHost: endpointHost, tls: settings}
+	if transport, ok := cache.transports[key]; ok {
+		return transport, nil
+	}
+	transport, err := newTransport(cache.proxies, endpointHost, settings)
+	if err != nil {
+		return nil, err
+	}
+	cache.transports[key] = transport
+	return transport, nil
+}
+
+// newTransport returns an http.Transport for the S3 endpoint at endpointHost
+// based on http.DefaultTransport, with the proxy and TLS settings from APT
+// applied.
+func newTransport(proxies proxySettings, endpointHost string, settings tlsSettings) (*http.Transport, error) {
+	transport := &http.Transport{}
+	if defaultTransport, ok := http.DefaultTransport.(*http.Transport); ok {
+		transport = defaultTransport.Clone()
+	}
+	transport.Proxy = proxies.forEndpoint(endpointHost)
+	if settings == (tlsSettings{}) {
+		return transport, nil
+	}
+
+	tlsConfig, err := newTLSConfig(settings)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// newTLSConfig returns the TLS config for the given CA bundle and client
+// certificate.
+func newTLSConfig(settings tlsSettings) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if settings.caInfo != "" {
+		roots, err := loadCAInfo(settings.caInfo)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = roots
+	}
+	if settings.sslCert != "" {
+		// The key may be stored in the same file as the certificate.
+		sslKey := settings.sslKey
+		if sslKey == "" {
+			sslKey = settings.sslCert
+		}
+		cert, err := tls.LoadX509KeyPair(settings.sslCert, sslKey)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate %s: %w", settings.sslCert, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// loadCAInfo reads the certificates of a PEM encoded CA bundle.
+func loadCAInfo(caInfo string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(caInfo)
+	if err != nil {
+		return nil, fmt.Errorf("loading CA bundle: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("loading CA bundle %s: %w", caInfo, errCAInfoNoCertificates)
+	}
+	return roots, nil
+}
+
+// certificateError returns the error underlying err if it was caused by the
+// certificate of an S3 endpoint failing verification, and nil otherwise.
+func certificateError(err error) error {
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	if errors.As(err, &unknownAuthorityErr) {
+		return unknownAuthorityErr
+	}
+	var hostnameErr x509.HostnameError
+	if errors.As(err, &hostnameErr) {
+		return hostnameErr
+	}
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &invalidErr) {
+		return invalidErr
+	}
+	// awserr.Error values do not support unwrapping, so their underlying
+	// errors need to be inspected explicitly.
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.OrigErr() != nil {
+		return certificateError(awsErr.OrigErr())
+	}
+	return nil
+}